@@ -0,0 +1,117 @@
+package pisces
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"strconv"
+
+	"github.com/xdatk/pisces/internal/constant"
+)
+
+const (
+	defaultRealm = "Restricted"
+)
+
+// BasicAuthValidator validates the credentials of a request and returns the authenticated
+// principal. A non-nil error is returned by the middleware as is.
+type BasicAuthValidator func(c *Context, username, password string) (principal interface{}, ok bool, err error)
+
+// BasicAuthConfig defines the config for BasicAuth middleware.
+type BasicAuthConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+
+	// Validator validates the credentials, required.
+	Validator BasicAuthValidator
+
+	// Realm is the protection space sent in the WWW-Authenticate challenge, default "Restricted".
+	Realm string
+}
+
+// DefaultBasicAuthConfig is the default BasicAuth middleware config.
+var DefaultBasicAuthConfig = BasicAuthConfig{
+	Skipper: DefaultSkipper,
+	Realm:   defaultRealm,
+}
+
+// BasicAuth returns a middleware which authenticates requests with HTTP Basic authentication.
+// The principal returned by the validator is stored on the Context, see Context.Principal,
+// requests failing authentication get a WWW-Authenticate challenge and ErrUnauthorized.
+func BasicAuth(validator BasicAuthValidator) MiddlewareFunc {
+	config := DefaultBasicAuthConfig
+	config.Validator = validator
+	return BasicAuthWithConfig(config)
+}
+
+// BasicAuthWithConfig returns a BasicAuth middleware with config, see BasicAuth.
+func BasicAuthWithConfig(config BasicAuthConfig) MiddlewareFunc {
+	if config.Validator == nil {
+		panic("basic auth validator must not be nil")
+	}
+	if config.Skipper == nil {
+		config.Skipper = DefaultBasicAuthConfig.Skipper
+	}
+	if config.Realm == "" {
+		config.Realm = DefaultBasicAuthConfig.Realm
+	}
+
+	challenge := "Basic realm=" + strconv.Quote(config.Realm) + `, charset="UTF-8"`
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			if username, password, ok := c.Request.BasicAuth(); ok {
+				principal, valid, err := config.Validator(c, username, password)
+				if err != nil {
+					return err
+				}
+				if valid {
+					c.principal = principal
+					return next(c)
+				}
+			}
+
+			c.SetHeader(constant.HeaderWWWAuthenticate, challenge)
+			return ErrUnauthorized
+		}
+	}
+}
+
+// BasicAuthAccounts returns a BasicAuthValidator accepting the given username/password pairs,
+// compared in constant time. The principal is the username.
+func BasicAuthAccounts(accounts map[string]string) BasicAuthValidator {
+	type account struct {
+		username [sha256.Size]byte
+		password [sha256.Size]byte
+	}
+	hashed := make([]account, 0, len(accounts))
+	for username, password := range accounts {
+		hashed = append(hashed, account{sha256.Sum256([]byte(username)), sha256.Sum256([]byte(password))})
+	}
+
+	return func(_ *Context, username, password string) (interface{}, bool, error) {
+		u := sha256.Sum256([]byte(username))
+		p := sha256.Sum256([]byte(password))
+
+		// every account is compared so the time does not depend on which one matches
+		match := 0
+		for i := range hashed {
+			match |= subtle.ConstantTimeCompare(u[:], hashed[i].username[:]) &
+				subtle.ConstantTimeCompare(p[:], hashed[i].password[:])
+		}
+		if match == 1 {
+			return username, true, nil
+		}
+		return nil, false, nil
+	}
+}
+
+// secureCompare compares a and b in constant time, regardless of their lengths.
+func secureCompare(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
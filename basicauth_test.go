@@ -0,0 +1,130 @@
+package pisces
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasicAuth(t *testing.T) {
+	e := New()
+	e.Use(BasicAuth(BasicAuthAccounts(map[string]string{"alice": "secret", "bob": "hunter2"})))
+	e.GET("/", func(c *Context) error {
+		return c.Text(http.StatusOK, c.Principal().(string))
+	})
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		auth     string
+		code     int
+		body     string
+	}{
+		{name: "valid", username: "alice", password: "secret", code: http.StatusOK, body: "alice"},
+		{name: "other account", username: "bob", password: "hunter2", code: http.StatusOK, body: "bob"},
+		{name: "wrong password", username: "alice", password: "hunter2", code: http.StatusUnauthorized},
+		{name: "password prefix", username: "alice", password: "secre", code: http.StatusUnauthorized},
+		{name: "unknown user", username: "carol", password: "secret", code: http.StatusUnauthorized},
+		{name: "empty credentials", code: http.StatusUnauthorized},
+		{name: "no credentials", auth: "-", code: http.StatusUnauthorized},
+		{name: "other scheme", auth: "Bearer token", code: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			switch tt.auth {
+			case "":
+				r.SetBasicAuth(tt.username, tt.password)
+			case "-":
+			default:
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Fatalf("got status %d, want %d", w.Code, tt.code)
+			}
+			if tt.code == http.StatusOK && w.Body.String() != tt.body {
+				t.Errorf("got principal %q, want %q", w.Body.String(), tt.body)
+			}
+			challenge := w.Header().Get("WWW-Authenticate")
+			if tt.code == http.StatusUnauthorized && challenge != `Basic realm="Restricted", charset="UTF-8"` {
+				t.Errorf("got challenge %q", challenge)
+			}
+			if tt.code == http.StatusOK && challenge != "" {
+				t.Errorf("got challenge %q on success", challenge)
+			}
+		})
+	}
+}
+
+func TestBasicAuthWithConfig(t *testing.T) {
+	errStore := errors.New("store down")
+	e := New()
+	e.Use(BasicAuthWithConfig(BasicAuthConfig{
+		Realm: `Admin "area"`,
+		Validator: func(c *Context, username, password string) (interface{}, bool, error) {
+			if username == "error" {
+				return nil, false, errStore
+			}
+			return nil, false, nil
+		},
+	}))
+	e.GET("/", func(c *Context) error {
+		return nil
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("error", "x")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError || w.Header().Get("WWW-Authenticate") != "" {
+		t.Errorf("got %d with challenge %q for a validator error", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+
+	r.SetBasicAuth("alice", "x")
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	if got := w.Header().Get("WWW-Authenticate"); got != `Basic realm="Admin \"area\"", charset="UTF-8"` {
+		t.Errorf("got challenge %q", got)
+	}
+}
+
+func TestSecureCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"key", "key", true},
+		{"", "", true},
+		{"key", "kez", false},
+		{"key", "ke", false},
+		{"key", "key1", false},
+		{"", "key", false},
+	}
+	for _, tt := range tests {
+		if got := secureCompare(tt.a, tt.b); got != tt.want {
+			t.Errorf("secureCompare(%q, %q) = %v", tt.a, tt.b, got)
+		}
+	}
+}
+
+func TestContextPrincipal(t *testing.T) {
+	e := New()
+	e.GET("/", func(c *Context) error {
+		if c.Principal() != nil {
+			t.Errorf("got principal %v before authentication", c.Principal())
+		}
+		c.SetPrincipal("alice")
+		if c.Principal() != "alice" {
+			t.Errorf("got principal %v", c.Principal())
+		}
+		return nil
+	})
+	performRequest(e, http.MethodGet, "/")
+	// the principal does not outlive the request
+	performRequest(e, http.MethodGet, "/")
+}
@@ -18,6 +18,21 @@ type Binder struct {
 	Body          map[string]binding.Body
 }
 
+// DefaultBinder returns a Binder wired with the bindings provided by the binding package.
+func DefaultBinder() Binder {
+	return Binder{
+		Param:         binding.UriBinding{},
+		Header:        binding.HeaderBinding{},
+		Query:         binding.FormBinding{},
+		Form:          binding.FormBinding{},
+		PostForm:      binding.FormBinding{},
+		MultipartFrom: binding.MultipartFormBinding{},
+		Body: map[string]binding.Body{
+			constant.MIMEApplicationJSON: binding.JsonBodyBinding{},
+		},
+	}
+}
+
 func (b Binder) Bind(c *Context, obj interface{}) error {
 	if c.Request.Method == http.MethodGet {
 		return b.Form.Bind(c.GetQuerys(), obj)
@@ -0,0 +1,89 @@
+package pisces
+
+import (
+	"io"
+	"net/http"
+)
+
+// BodyLimitConfig defines the config for BodyLimit middleware.
+type BodyLimitConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+
+	// Limit is the maximum size, in bytes, of the request body.
+	Limit int64
+}
+
+// BodyLimit returns a middleware which caps the request body to limit bytes. Requests whose
+// Content-Length exceeds it, and reads past it, fail with ErrStatusRequestEntityTooLarge.
+// See WithMaxBodySize to limit the body of every request.
+func BodyLimit(limit int64) MiddlewareFunc {
+	return BodyLimitWithConfig(BodyLimitConfig{Limit: limit})
+}
+
+// BodyLimitWithConfig returns a BodyLimit middleware with config, see BodyLimit.
+func BodyLimitWithConfig(config BodyLimitConfig) MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultSkipper
+	}
+	if config.Limit <= 0 {
+		panic("body limit must be positive")
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			if c.Request.ContentLength > config.Limit {
+				return ErrStatusRequestEntityTooLarge
+			}
+
+			c.limitBody(config.Limit)
+			return next(c)
+		}
+	}
+}
+
+// limitBody wraps the request body with http.MaxBytesReader,
+// reading past limit returns ErrStatusRequestEntityTooLarge.
+func (c *Context) limitBody(limit int64) {
+	body := c.Request.Body
+	if body == nil || body == http.NoBody {
+		return
+	}
+
+	counter := &countingReader{ReadCloser: body}
+	c.Request.Body = &limitedBody{
+		ReadCloser: http.MaxBytesReader(c.writerMem.ResponseWriter, counter, limit),
+		counter:    counter,
+		limit:      limit,
+	}
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// limitedBody translates the error of http.MaxBytesReader into ErrStatusRequestEntityTooLarge.
+type limitedBody struct {
+	io.ReadCloser
+	counter *countingReader
+	limit   int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.counter.n > b.limit {
+		err = ErrStatusRequestEntityTooLarge
+	}
+	return n, err
+}
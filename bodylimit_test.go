@@ -0,0 +1,65 @@
+package pisces
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func readBody(c *Context) error {
+	b, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	return c.Data(http.StatusOK, "text/plain", b)
+}
+
+func TestBodyLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		mw      []MiddlewareFunc
+		body    string
+		chunked bool
+		code    int
+	}{
+		{"under the limit", nil, []MiddlewareFunc{BodyLimit(8)}, "12345678", false, http.StatusOK},
+		{"Content-Length over the limit", nil, []MiddlewareFunc{BodyLimit(8)}, "123456789", false, http.StatusRequestEntityTooLarge},
+		{"read over the limit", nil, []MiddlewareFunc{BodyLimit(8)}, "123456789", true, http.StatusRequestEntityTooLarge},
+		{"engine option", []Option{WithMaxBodySize(8)}, nil, "123456789", true, http.StatusRequestEntityTooLarge},
+		{"engine option under the limit", []Option{WithMaxBodySize(8)}, nil, "1234", true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := New(tt.opts...)
+			e.POST("/", readBody, tt.mw...)
+
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.chunked {
+				r.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Errorf("got status %d, want %d", w.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestServeHTTPRestoresTheBody(t *testing.T) {
+	e := New(WithMaxBodySize(8))
+	e.POST("/", readBody, BodyLimit(4))
+
+	body := io.NopCloser(strings.NewReader("12"))
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Body = body
+	e.ServeHTTP(httptest.NewRecorder(), r)
+
+	if r.Body != body {
+		t.Errorf("the request still holds the body wrapper %T", r.Body)
+	}
+}
@@ -0,0 +1,569 @@
+package pisces
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xdatk/pisces/internal/constant"
+)
+
+const (
+	defaultCacheTTL         = time.Minute
+	defaultCacheMaxBodySize = 1 << 20
+	defaultCacheMaxEntries  = 10000
+)
+
+// CachedResponse is a complete response kept by a CacheStore.
+type CachedResponse struct {
+	Status   int
+	Header   http.Header
+	Body     []byte
+	Tags     []string
+	StoredAt time.Time
+}
+
+// CacheStore keeps the responses of Cache middleware, it must be safe for concurrent use.
+type CacheStore interface {
+	// Get returns the response stored under key, if it has not expired.
+	Get(ctx context.Context, key string) (*CachedResponse, bool, error)
+	// Set stores the response under key for ttl.
+	Set(ctx context.Context, key string, resp *CachedResponse, ttl time.Duration) error
+	// Delete removes the response stored under key.
+	Delete(ctx context.Context, key string) error
+	// InvalidateTags removes the responses having one of the tags.
+	InvalidateTags(ctx context.Context, tags ...string) error
+}
+
+// CacheConfig defines the config for Cache middleware.
+type CacheConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+
+	// Store keeps the responses, required.
+	Store CacheStore
+
+	// TTL is how long a response is kept, default one minute.
+	// The max-age and s-maxage directives of the response override it.
+	TTL time.Duration
+
+	// RouteTTLs overrides TTL for the routes of the given templates, see Context.FullPath.
+	RouteTTLs map[string]time.Duration
+
+	// VaryHeaders are the request headers the response depends on, they are part of the key.
+	// Responses varying on another header are not stored.
+	VaryHeaders []string
+
+	// Tags returns the tags of the response of a request, see CacheStore.InvalidateTags.
+	Tags func(*Context) []string
+
+	// MaxBodySize is the size above which a response is not stored, default 1 MiB.
+	MaxBodySize int
+
+	// KeyFunc returns who the response of a request is for, such as the authenticated
+	// principal, it is part of the key. Setting it opts in to store the responses of requests
+	// with an Authorization or a Cookie header, so it must run after the authentication
+	// middleware.
+	KeyFunc func(*Context) string
+}
+
+// DefaultCacheConfig is the default Cache middleware config.
+var DefaultCacheConfig = CacheConfig{
+	Skipper:     DefaultSkipper,
+	TTL:         defaultCacheTTL,
+	MaxBodySize: defaultCacheMaxBodySize,
+}
+
+// Cache returns a middleware which stores the responses of GET requests in store and serves
+// the GET and HEAD requests from it.
+//
+// The key is made of the path and the sorted query. Requests with the no-store directive
+// bypass the cache, the ones with no-cache or max-age=0 are not served from it but refresh it.
+// Responses with a cacheable status are stored, unless they set a cookie or have the no-store,
+// no-cache or private directive. Unless CacheConfig.KeyFunc is set, the responses of requests
+// with an Authorization header are only stored if they have the public, s-maxage or
+// must-revalidate directive, and the ones of requests with a Cookie header if they have the
+// public or s-maxage directive.
+func Cache(store CacheStore) MiddlewareFunc {
+	config := DefaultCacheConfig
+	config.Store = store
+	return CacheWithConfig(config)
+}
+
+// CacheWithConfig returns a Cache middleware with config, see Cache.
+func CacheWithConfig(config CacheConfig) MiddlewareFunc {
+	if config.Store == nil {
+		panic("cache store must not be nil")
+	}
+	if config.Skipper == nil {
+		config.Skipper = DefaultCacheConfig.Skipper
+	}
+	if config.TTL <= 0 {
+		config.TTL = DefaultCacheConfig.TTL
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = DefaultCacheConfig.MaxBodySize
+	}
+
+	varyNames := make([]string, len(config.VaryHeaders))
+	vary := make(map[string]struct{}, len(config.VaryHeaders))
+	for i, h := range config.VaryHeaders {
+		varyNames[i] = http.CanonicalHeaderKey(h)
+		vary[varyNames[i]] = struct{}{}
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			method := c.Method()
+			if config.Skipper(c) || method != http.MethodGet && method != http.MethodHead || c.IsWebSocket() {
+				return next(c)
+			}
+
+			directives := parseCacheControl(c.Header(constant.HeaderCacheControl))
+			if _, ok := directives["no-store"]; ok {
+				return next(c)
+			}
+
+			ctx := c.Request.Context()
+			key := cacheKey(c, varyNames)
+			if config.KeyFunc != nil {
+				key = config.KeyFunc(c) + "\n" + key
+			}
+			authorized := config.KeyFunc == nil && c.Header(constant.HeaderAuthorization) != ""
+			withCookie := config.KeyFunc == nil && c.Header(constant.HeaderCookie) != ""
+
+			_, noCache := directives["no-cache"]
+			if maxAge, ok := directives["max-age"]; !noCache && maxAge != "0" {
+				resp, found, err := config.Store.Get(ctx, key)
+				if err != nil {
+					log.Printf("cache: %v", err)
+				}
+				if found && (!ok || !olderThan(resp.StoredAt, maxAge)) {
+					return writeCachedResponse(c, resp)
+				}
+			}
+
+			if method != http.MethodGet {
+				return next(c)
+			}
+
+			// the headers set by the outer middlewares, such as X-Request-ID, are theirs to set
+			// again on every request
+			outer := c.Writer.Header().Clone()
+
+			rw := &cacheWriter{ResponseWriter: c.Writer, status: http.StatusOK, max: config.MaxBodySize}
+			c.Writer = rw
+			defer func() {
+				c.Writer = rw.ResponseWriter
+			}()
+			err := next(c)
+
+			if err != nil || !rw.wroteHeader || rw.overflow {
+				return err
+			}
+
+			removeOuterHeader(rw.header, outer)
+
+			ttl, ok := cacheTTL(rw.status, rw.header, vary, varyHeaders(outer))
+			// a session cookie makes the response the client's own, like a credential does
+			if !ok || authorized && !hasCacheDirective(rw.header, "public", "s-maxage", "must-revalidate") ||
+				withCookie && !hasCacheDirective(rw.header, "public", "s-maxage") {
+				return nil
+			}
+			if ttl == 0 {
+				ttl = config.TTL
+				if d, ok := config.RouteTTLs[c.fullPath]; ok {
+					ttl = d
+				}
+			}
+			if ttl <= 0 {
+				return nil
+			}
+
+			resp := &CachedResponse{
+				Status:   rw.status,
+				Header:   rw.header,
+				Body:     rw.body,
+				StoredAt: time.Now(),
+			}
+			if config.Tags != nil {
+				resp.Tags = config.Tags(c)
+			}
+			if err := config.Store.Set(ctx, key, resp, ttl); err != nil {
+				log.Printf("cache: %v", err)
+			}
+			return nil
+		}
+	}
+}
+
+// cacheKey returns the key of the request, made of the method, the path, the sorted query and
+// the values of the vary headers. HEAD requests share the key of GET requests.
+func cacheKey(c *Context, varyHeaders []string) string {
+	var b strings.Builder
+	b.WriteString(http.MethodGet)
+	b.WriteByte(' ')
+	b.WriteString(c.Path())
+
+	query := c.GetQuerys()
+	if len(query) > 0 {
+		keys := make([]string, 0, len(query))
+		for k := range query {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		sep := byte('?')
+		for _, k := range keys {
+			values := append([]string(nil), query[k]...)
+			sort.Strings(values)
+			for _, v := range values {
+				b.WriteByte(sep)
+				b.WriteString(url.QueryEscape(k))
+				b.WriteByte('=')
+				b.WriteString(url.QueryEscape(v))
+				sep = '&'
+			}
+		}
+	}
+
+	for _, h := range varyHeaders {
+		b.WriteByte('\n')
+		b.WriteString(h)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(c.HeaderValues(h), ","))
+	}
+	return b.String()
+}
+
+// cacheableStatus are the status codes which are cacheable by default, per RFC 7231 section 6.1.
+var cacheableStatus = map[int]struct{}{
+	http.StatusOK:                   {},
+	http.StatusNonAuthoritativeInfo: {},
+	http.StatusNoContent:            {},
+	http.StatusMultipleChoices:      {},
+	http.StatusMovedPermanently:     {},
+	http.StatusNotFound:             {},
+	http.StatusMethodNotAllowed:     {},
+	http.StatusGone:                 {},
+	http.StatusRequestURITooLong:    {},
+	http.StatusNotImplemented:       {},
+}
+
+// cacheTTL reports whether the response can be stored, and for how long if its
+// Cache-Control header tells it.
+func cacheTTL(status int, header http.Header, vary, outerVary map[string]struct{}) (time.Duration, bool) {
+	if _, ok := cacheableStatus[status]; !ok {
+		return 0, false
+	}
+	if header.Get(constant.HeaderSetCookie) != "" {
+		return 0, false
+	}
+	// the outer middlewares vary on their own, such as Compress on Accept-Encoding
+	for h := range varyHeaders(header) {
+		_, ok := vary[h]
+		_, outer := outerVary[h]
+		if !ok && !outer {
+			return 0, false
+		}
+	}
+
+	directives := parseCacheControl(header.Get(constant.HeaderCacheControl))
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[d]; ok {
+			return 0, false
+		}
+	}
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, ok := directives[d]; ok {
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return 0, true
+}
+
+// hasCacheDirective reports whether the Cache-Control header of a response has one of names.
+func hasCacheDirective(header http.Header, names ...string) bool {
+	directives := parseCacheControl(header.Get(constant.HeaderCacheControl))
+	for _, d := range names {
+		if _, ok := directives[d]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// varyHeaders returns the canonical names of the Vary header.
+func varyHeaders(header http.Header) map[string]struct{} {
+	values := header.Values(constant.HeaderVary)
+	if len(values) == 0 {
+		return nil
+	}
+	names := make(map[string]struct{})
+	for _, v := range values {
+		for _, h := range strings.Split(v, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				names[http.CanonicalHeaderKey(h)] = struct{}{}
+			}
+		}
+	}
+	return names
+}
+
+// parseCacheControl returns the directives of a Cache-Control header value, by lower case name.
+func parseCacheControl(value string) map[string]string {
+	if value == "" {
+		return nil
+	}
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg := part, ""
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			name, arg = part[:i], strings.Trim(strings.TrimSpace(part[i+1:]), `"`)
+		}
+		directives[strings.ToLower(strings.TrimSpace(name))] = arg
+	}
+	return directives
+}
+
+// removeOuterHeader removes from header the fields whose values are the ones of outer,
+// the header of the response before the next handlers ran.
+func removeOuterHeader(header, outer http.Header) {
+	for k, v := range header {
+		if equalValues(outer[k], v) {
+			delete(header, k)
+		}
+	}
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func olderThan(t time.Time, maxAge string) bool {
+	seconds, err := strconv.Atoi(maxAge)
+	if err != nil {
+		return true
+	}
+	return time.Since(t) > time.Duration(seconds)*time.Second
+}
+
+func writeCachedResponse(c *Context, resp *CachedResponse) error {
+	header := c.Writer.Header()
+	for k, v := range resp.Header {
+		header[k] = append([]string(nil), v...)
+	}
+	header.Set(constant.HeaderAge, strconv.FormatInt(int64(time.Since(resp.StoredAt)/time.Second), 10))
+
+	c.Writer.WriteHeader(resp.Status)
+	if c.Method() == http.MethodHead || len(resp.Body) == 0 {
+		return nil
+	}
+	_, err := c.Writer.Write(resp.Body)
+	return err
+}
+
+// cacheWriter writes the response through and keeps a copy of it, up to max bytes of body.
+type cacheWriter struct {
+	ResponseWriter
+	max int
+
+	status      int
+	header      http.Header
+	wroteHeader bool
+	body        []byte
+	overflow    bool
+}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.header = w.Header().Clone()
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.overflow {
+		if len(w.body)+len(p) > w.max {
+			w.overflow = true
+			w.body = nil
+		} else {
+			w.body = append(w.body, p...)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *cacheWriter) committed() bool {
+	return isCommitted(w.ResponseWriter)
+}
+
+// Hijack implements the http.Hijacker interface, a hijacked response is not stored.
+func (w *cacheWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.overflow = true
+	return w.ResponseWriter.Hijack()
+}
+
+// MemoryCacheStoreConfig defines the config for MemoryCacheStore.
+type MemoryCacheStoreConfig struct {
+	// MaxEntries is the number of responses kept, default 10000.
+	MaxEntries int
+
+	// MaxBytes is the total size of the bodies kept, zero is unlimited.
+	MaxBytes int
+}
+
+// MemoryCacheStore is an in-memory CacheStore evicting the least recently used responses.
+type MemoryCacheStore struct {
+	config MemoryCacheStoreConfig
+
+	mu      sync.Mutex
+	lru     list.List
+	entries map[string]*list.Element
+	tags    map[string]map[string]struct{}
+	bytes   int
+}
+
+type memoryCacheEntry struct {
+	key       string
+	resp      *CachedResponse
+	expiresAt time.Time
+}
+
+// NewMemoryCacheStore returns a MemoryCacheStore configured by config.
+func NewMemoryCacheStore(config MemoryCacheStoreConfig) *MemoryCacheStore {
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = defaultCacheMaxEntries
+	}
+	return &MemoryCacheStore{
+		config:  config,
+		entries: make(map[string]*list.Element),
+		tags:    make(map[string]map[string]struct{}),
+	}
+}
+
+// Get implements CacheStore.
+func (s *MemoryCacheStore) Get(_ context.Context, key string) (*CachedResponse, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := elem.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		s.remove(elem)
+		return nil, false, nil
+	}
+	s.lru.MoveToFront(elem)
+	return entry.resp, true, nil
+}
+
+// Set implements CacheStore.
+func (s *MemoryCacheStore) Set(_ context.Context, key string, resp *CachedResponse, ttl time.Duration) error {
+	if s.config.MaxBytes > 0 && len(resp.Body) > s.config.MaxBytes {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[key]; ok {
+		s.remove(elem)
+	}
+
+	entry := &memoryCacheEntry{key: key, resp: resp, expiresAt: time.Now().Add(ttl)}
+	s.entries[key] = s.lru.PushFront(entry)
+	s.bytes += len(resp.Body)
+	for _, tag := range resp.Tags {
+		keys, ok := s.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			s.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+
+	for s.lru.Len() > s.config.MaxEntries || s.config.MaxBytes > 0 && s.bytes > s.config.MaxBytes {
+		s.remove(s.lru.Back())
+	}
+	return nil
+}
+
+// Delete implements CacheStore.
+func (s *MemoryCacheStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[key]; ok {
+		s.remove(elem)
+	}
+	return nil
+}
+
+// InvalidateTags implements CacheStore.
+func (s *MemoryCacheStore) InvalidateTags(_ context.Context, tags ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, tag := range tags {
+		for key := range s.tags[tag] {
+			if elem, ok := s.entries[key]; ok {
+				s.remove(elem)
+			}
+		}
+	}
+	return nil
+}
+
+// Len returns the number of responses kept.
+func (s *MemoryCacheStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+func (s *MemoryCacheStore) remove(elem *list.Element) {
+	entry := s.lru.Remove(elem).(*memoryCacheEntry)
+	delete(s.entries, entry.key)
+	s.bytes -= len(entry.resp.Body)
+	for _, tag := range entry.resp.Tags {
+		if keys, ok := s.tags[tag]; ok {
+			delete(keys, entry.key)
+			if len(keys) == 0 {
+				delete(s.tags, tag)
+			}
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package pisces
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCacheMiddleware(t *testing.T) {
+	store := NewMemoryCacheStore(MemoryCacheStoreConfig{})
+	calls := 0
+
+	e := New()
+	e.Use(RequestID())
+	e.GET("/items/:id", func(c *Context) error {
+		calls++
+		return c.Text(http.StatusOK, c.Param("id")+" "+c.Header("Accept-Language")+" "+strconv.Itoa(calls))
+	}, CacheWithConfig(CacheConfig{
+		Store:       store,
+		VaryHeaders: []string{"Accept-Language"},
+		Tags: func(c *Context) []string {
+			return []string{"item:" + c.Param("id")}
+		},
+	}))
+
+	get := func(target, language, cacheControl string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if language != "" {
+			r.Header.Set("Accept-Language", language)
+		}
+		if cacheControl != "" {
+			r.Header.Set("Cache-Control", cacheControl)
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		return w
+	}
+
+	first := get("/items/1?b=2&a=1", "en", "")
+	if first.Body.String() != "1 en 1" {
+		t.Fatalf("got %q", first.Body.String())
+	}
+
+	hit := get("/items/1?a=1&b=2", "en", "")
+	if hit.Body.String() != "1 en 1" {
+		t.Errorf("got %q, want the response served from the cache", hit.Body.String())
+	}
+	if hit.Header().Get("Age") == "" {
+		t.Error("missing Age header")
+	}
+	if id := hit.Header().Get("X-Request-ID"); id == "" || id == first.Header().Get("X-Request-ID") {
+		t.Errorf("got request id %q, want a new one", id)
+	}
+
+	if w := get("/items/1?a=1&b=2", "fr", ""); w.Body.String() != "1 fr 2" {
+		t.Errorf("got %q, want another key for another Accept-Language", w.Body.String())
+	}
+	if w := get("/items/1?a=1&b=2", "en", "no-cache"); w.Body.String() != "1 en 3" {
+		t.Errorf("got %q, want no-cache to skip the cache", w.Body.String())
+	}
+	if w := get("/items/1?a=1&b=2", "en", ""); w.Body.String() != "1 en 3" {
+		t.Errorf("got %q, want no-cache to refresh the cache", w.Body.String())
+	}
+
+	if err := store.InvalidateTags(context.Background(), "item:1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := store.Len(); n != 0 {
+		t.Fatalf("got %d entries after invalidation, want 0", n)
+	}
+	if w := get("/items/1?a=1&b=2", "en", ""); w.Body.String() != "1 en 4" {
+		t.Errorf("got %q, want the handler to run after invalidation", w.Body.String())
+	}
+}
+
+func TestCacheSkipsUncacheableResponses(t *testing.T) {
+	store := NewMemoryCacheStore(MemoryCacheStoreConfig{})
+
+	e := New()
+	e.GET("/private", func(c *Context) error {
+		c.SetHeader("Cache-Control", "private")
+		return c.Text(http.StatusOK, "private")
+	}, Cache(store))
+	e.GET("/cookie", func(c *Context) error {
+		c.SetCookie(&http.Cookie{Name: "session", Value: "1"})
+		return c.Text(http.StatusOK, "cookie")
+	}, Cache(store))
+	e.GET("/vary", func(c *Context) error {
+		c.SetHeader("Vary", "Authorization")
+		return c.Text(http.StatusOK, "vary")
+	}, Cache(store))
+
+	for _, path := range []string{"/private", "/cookie", "/vary"} {
+		performRequest(e, http.MethodGet, path)
+	}
+	if n := store.Len(); n != 0 {
+		t.Errorf("got %d entries, want 0", n)
+	}
+}
+
+func TestMemoryCacheStoreEviction(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryCacheStore(MemoryCacheStoreConfig{MaxEntries: 2})
+
+	for _, key := range []string{"a", "b"} {
+		_ = store.Set(ctx, key, &CachedResponse{Status: http.StatusOK}, time.Minute)
+	}
+	_, _, _ = store.Get(ctx, "a")
+	_ = store.Set(ctx, "c", &CachedResponse{Status: http.StatusOK}, time.Minute)
+
+	if _, ok, _ := store.Get(ctx, "b"); ok {
+		t.Error("got b, want the least recently used entry evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok, _ := store.Get(ctx, key); !ok {
+			t.Errorf("missing %s", key)
+		}
+	}
+}
+
+func TestCacheAuthorizedRequests(t *testing.T) {
+	auth := BasicAuth(BasicAuthAccounts(map[string]string{"alice": "a", "bob": "b"}))
+	handler := func(c *Context) error {
+		if c.Query("public") != "" {
+			c.SetHeader("Cache-Control", "public, max-age=60")
+		}
+		return c.Data(http.StatusOK, "text/plain", []byte(c.Principal().(string)))
+	}
+
+	get := func(e *Engine, target, username string) string {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if username != "" {
+			r.SetBasicAuth(username, username[:1])
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	t.Run("not stored by default", func(t *testing.T) {
+		store := NewMemoryCacheStore(MemoryCacheStoreConfig{})
+		e := New()
+		e.GET("/", handler, Cache(store), auth)
+
+		get(e, "/", "alice")
+		if got := get(e, "/", "bob"); got != "bob" {
+			t.Errorf("bob got %q", got)
+		}
+		if got := get(e, "/", ""); got == "alice" || got == "bob" {
+			t.Errorf("an anonymous request got %q", got)
+		}
+		if n := store.Len(); n != 0 {
+			t.Errorf("got %d entries", n)
+		}
+	})
+
+	t.Run("stored when public", func(t *testing.T) {
+		store := NewMemoryCacheStore(MemoryCacheStoreConfig{})
+		e := New()
+		e.GET("/", handler, Cache(store), auth)
+
+		get(e, "/?public=1", "alice")
+		if n := store.Len(); n != 1 {
+			t.Errorf("got %d entries for a public response", n)
+		}
+	})
+
+	t.Run("stored by key", func(t *testing.T) {
+		store := NewMemoryCacheStore(MemoryCacheStoreConfig{})
+		e := New()
+		e.GET("/", handler, auth, CacheWithConfig(CacheConfig{
+			Store: store,
+			KeyFunc: func(c *Context) string {
+				return c.Principal().(string)
+			},
+		}))
+
+		get(e, "/", "alice")
+		if got := get(e, "/", "bob"); got != "bob" {
+			t.Errorf("bob got %q", got)
+		}
+		if got := get(e, "/", "alice"); got != "alice" || store.Len() != 2 {
+			t.Errorf("alice got %q with %d entries", got, store.Len())
+		}
+	})
+}
+
+func TestCacheRequestsWithCookies(t *testing.T) {
+	store := NewMemoryCacheStore(MemoryCacheStoreConfig{})
+	e := New()
+	e.GET("/", func(c *Context) error {
+		if c.Query("public") != "" {
+			c.SetHeader("Cache-Control", "public, max-age=60")
+		}
+		cookie, err := c.Request.Cookie("session")
+		if err != nil {
+			return c.Data(http.StatusOK, "text/plain", []byte("anonymous"))
+		}
+		return c.Data(http.StatusOK, "text/plain", []byte(cookie.Value))
+	}, Cache(store))
+
+	get := func(target, session string) string {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if session != "" {
+			r.AddCookie(&http.Cookie{Name: "session", Value: session})
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	get("/", "alice")
+	if got := get("/", "bob"); got != "bob" {
+		t.Errorf("bob got %q", got)
+	}
+	if got := get("/", ""); got != "anonymous" {
+		t.Errorf("an anonymous request got %q", got)
+	}
+
+	get("/?public=1", "alice")
+	if got := get("/?public=1", ""); got != "alice" {
+		t.Errorf("got %q, want the stored public response", got)
+	}
+}
+
+func TestCacheRestoresTheWriterOnPanic(t *testing.T) {
+	store := NewMemoryCacheStore(MemoryCacheStoreConfig{})
+	var w ResponseWriter
+	e := New()
+	e.Use(func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			defer func() {
+				w = c.Writer
+			}()
+			return next(c)
+		}
+	}, RecoverWithConfig(RecoverConfig{Reporter: func(*Context, *PanicError) {}}))
+	e.GET("/", func(c *Context) error {
+		panic("boom")
+	}, Cache(store))
+
+	if rec := performRequest(e, http.MethodGet, "/"); rec.Code != http.StatusInternalServerError || store.Len() != 0 {
+		t.Errorf("got status %d with %d entries", rec.Code, store.Len())
+	}
+	if _, ok := w.(*cacheWriter); ok {
+		t.Error("the cache writer was left on the Context")
+	}
+}
@@ -0,0 +1,365 @@
+package pisces
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xdatk/pisces/internal/constant"
+)
+
+const (
+	defaultCircuitBreakerConsecutiveFailures = 5
+	defaultCircuitBreakerMinRequests         = 10
+	defaultCircuitBreakerWindow              = 10 * time.Second
+	defaultCircuitBreakerOpenTimeout         = 30 * time.Second
+	defaultCircuitBreakerHalfOpenRequests    = 1
+)
+
+// ErrCircuitOpen is returned by CircuitBreaker middleware when the circuit is open.
+var ErrCircuitOpen = NewHTTPError(http.StatusServiceUnavailable, "circuit breaker open")
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets every request through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects every request with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of probe requests through.
+	CircuitHalfOpen
+)
+
+// String returns the name of the state.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerConfig defines the config for CircuitBreaker.
+type CircuitBreakerConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+
+	// ConsecutiveFailures is the number of consecutive failures which trips the circuit,
+	// default 5. A negative value disables this trigger.
+	ConsecutiveFailures int
+
+	// FailureRate is the ratio of failed requests, between 0 and 1, within Window which trips
+	// the circuit once MinRequests requests completed in it. Default 0 disables this trigger.
+	FailureRate float64
+
+	// MinRequests is the number of requests within Window below which FailureRate is not
+	// evaluated, default 10.
+	MinRequests int
+
+	// Window is the period the requests are counted over while the circuit is closed,
+	// the counts are reset at the end of each one. Default 10 seconds.
+	Window time.Duration
+
+	// OpenTimeout is how long the circuit stays open before letting probes through,
+	// default 30 seconds.
+	OpenTimeout time.Duration
+
+	// HalfOpenRequests is the number of probes allowed at once while the circuit is half-open,
+	// the circuit closes once as many succeed and opens again on the first failure. Default 1.
+	HalfOpenRequests int
+
+	// IsFailure reports whether a request failed from the status of its response and the error
+	// returned by the next handlers, the status is the one the error handler sends for err when
+	// nothing was written. Default a server error: an error which is not an HTTPError, an
+	// HTTPError with a 5xx code, or a 5xx response status when no error is returned.
+	IsFailure func(c *Context, status int, err error) bool
+
+	// OnStateChange is called on every state change, outside of the lock of the breaker.
+	OnStateChange func(from, to CircuitState)
+}
+
+// CircuitBreakerSnapshot is a snapshot of the state of a CircuitBreaker.
+type CircuitBreakerSnapshot struct {
+	State CircuitState
+	// Since is when the circuit entered State.
+	Since time.Time
+	// Requests and Failures are counted over the current window while the circuit is closed,
+	// and since it entered the half-open state otherwise.
+	Requests            int
+	Failures            int
+	ConsecutiveFailures int
+	// Rejected is the number of requests rejected since the breaker was created.
+	Rejected uint64
+}
+
+// CircuitBreaker fails fast while the routes behind it keep failing, shielding the
+// dependencies they call while they recover. A breaker is shared by every route its
+// middleware is added to, create one per dependency.
+type CircuitBreaker struct {
+	config CircuitBreakerConfig
+
+	mu                  sync.Mutex
+	state               CircuitState
+	since               time.Time
+	generation          uint64
+	windowEnd           time.Time
+	requests            int
+	failures            int
+	consecutiveFailures int
+	successes           int
+	probes              int
+	rejected            uint64
+
+	now func() time.Time
+}
+
+// NewCircuitBreaker returns a closed CircuitBreaker configured by config.
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	if config.Skipper == nil {
+		config.Skipper = DefaultSkipper
+	}
+	if config.ConsecutiveFailures == 0 {
+		config.ConsecutiveFailures = defaultCircuitBreakerConsecutiveFailures
+	}
+	if config.FailureRate < 0 || config.FailureRate > 1 {
+		panic("circuit breaker failure rate must be between 0 and 1")
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = defaultCircuitBreakerMinRequests
+	}
+	if config.Window <= 0 {
+		config.Window = defaultCircuitBreakerWindow
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = defaultCircuitBreakerOpenTimeout
+	}
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = defaultCircuitBreakerHalfOpenRequests
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = isServerFailure
+	}
+
+	b := &CircuitBreaker{
+		config: config,
+		now:    time.Now,
+	}
+	b.since = b.now()
+	b.windowEnd = b.since.Add(config.Window)
+	return b
+}
+
+// Middleware returns a middleware which runs the next handlers unless the circuit is open,
+// the requests it rejects fail with ErrCircuitOpen and a Retry-After header.
+func (b *CircuitBreaker) Middleware() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if b.config.Skipper(c) {
+				return next(c)
+			}
+
+			generation, retryAfter, ok := b.allow()
+			if !ok {
+				seconds := int(math.Ceil(retryAfter.Seconds()))
+				if seconds < 1 {
+					seconds = 1
+				}
+				c.SetHeader(constant.HeaderRetryAfter, strconv.Itoa(seconds))
+				return ErrCircuitOpen
+			}
+
+			done := false
+			defer func() {
+				if !done {
+					// the handlers panicked
+					b.record(generation, true)
+				}
+			}()
+
+			w := c.Writer
+			rec := newStatusRecorder(w)
+			c.Writer = rec
+			defer func() {
+				c.Writer = w
+			}()
+
+			err := next(c)
+			done = true
+			status, _ := rec.result(err)
+			b.record(generation, b.config.IsFailure(c, status, err))
+			return err
+		}
+	}
+}
+
+// State returns the current state of the circuit.
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	state, transition := b.current(b.now())
+	b.mu.Unlock()
+	b.notify(transition)
+	return state
+}
+
+// Snapshot returns a snapshot of the state of the breaker.
+func (b *CircuitBreaker) Snapshot() CircuitBreakerSnapshot {
+	b.mu.Lock()
+	_, transition := b.current(b.now())
+	snapshot := CircuitBreakerSnapshot{
+		State:               b.state,
+		Since:               b.since,
+		Requests:            b.requests,
+		Failures:            b.failures,
+		ConsecutiveFailures: b.consecutiveFailures,
+		Rejected:            b.rejected,
+	}
+	b.mu.Unlock()
+	b.notify(transition)
+	return snapshot
+}
+
+// circuitTransition is a state change, notified once the lock is released.
+type circuitTransition struct {
+	from, to CircuitState
+}
+
+// allow reports whether a request can run and returns the generation it runs in,
+// or how long the circuit stays open.
+func (b *CircuitBreaker) allow() (uint64, time.Duration, bool) {
+	now := b.now()
+
+	b.mu.Lock()
+	state, transition := b.current(now)
+	generation := b.generation
+	var retryAfter time.Duration
+	ok := true
+	switch state {
+	case CircuitOpen:
+		retryAfter = b.since.Add(b.config.OpenTimeout).Sub(now)
+		ok = false
+	case CircuitHalfOpen:
+		if b.probes >= b.config.HalfOpenRequests {
+			retryAfter = time.Second
+			ok = false
+		} else {
+			b.probes++
+		}
+	}
+	if !ok {
+		b.rejected++
+	}
+	b.mu.Unlock()
+
+	b.notify(transition)
+	return generation, retryAfter, ok
+}
+
+// record counts the outcome of a request, unless the state changed since it started.
+func (b *CircuitBreaker) record(generation uint64, failed bool) {
+	now := b.now()
+
+	b.mu.Lock()
+	state, transition := b.current(now)
+	if generation != b.generation {
+		b.mu.Unlock()
+		b.notify(transition)
+		return
+	}
+
+	b.requests++
+	if failed {
+		b.failures++
+		b.consecutiveFailures++
+	} else {
+		b.consecutiveFailures = 0
+	}
+
+	switch state {
+	case CircuitClosed:
+		if failed && b.tripped() {
+			transition = b.setState(CircuitOpen, now)
+		}
+	case CircuitHalfOpen:
+		b.probes--
+		if failed {
+			transition = b.setState(CircuitOpen, now)
+		} else if b.successes++; b.successes >= b.config.HalfOpenRequests {
+			transition = b.setState(CircuitClosed, now)
+		}
+	}
+	b.mu.Unlock()
+
+	b.notify(transition)
+}
+
+func (b *CircuitBreaker) tripped() bool {
+	if b.config.ConsecutiveFailures > 0 && b.consecutiveFailures >= b.config.ConsecutiveFailures {
+		return true
+	}
+	return b.config.FailureRate > 0 && b.requests >= b.config.MinRequests &&
+		float64(b.failures) >= b.config.FailureRate*float64(b.requests)
+}
+
+// current moves the circuit out of the states which expired at now, with the lock held.
+func (b *CircuitBreaker) current(now time.Time) (CircuitState, *circuitTransition) {
+	var transition *circuitTransition
+	switch b.state {
+	case CircuitClosed:
+		if !now.Before(b.windowEnd) {
+			// consecutive failures are counted across windows
+			b.requests = 0
+			b.failures = 0
+			b.windowEnd = now.Add(b.config.Window)
+		}
+	case CircuitOpen:
+		if !now.Before(b.since.Add(b.config.OpenTimeout)) {
+			transition = b.setState(CircuitHalfOpen, now)
+		}
+	}
+	return b.state, transition
+}
+
+// setState changes the state of the circuit with the lock held, requests started before
+// are not counted anymore.
+func (b *CircuitBreaker) setState(state CircuitState, now time.Time) *circuitTransition {
+	transition := &circuitTransition{from: b.state, to: state}
+	b.state = state
+	b.since = now
+	b.generation++
+	b.resetCounts()
+	b.probes = 0
+	b.windowEnd = now.Add(b.config.Window)
+	return transition
+}
+
+func (b *CircuitBreaker) resetCounts() {
+	b.requests = 0
+	b.failures = 0
+	b.consecutiveFailures = 0
+	b.successes = 0
+}
+
+func (b *CircuitBreaker) notify(transition *circuitTransition) {
+	if transition != nil && b.config.OnStateChange != nil {
+		b.config.OnStateChange(transition.from, transition.to)
+	}
+}
+
+func isServerFailure(_ *Context, status int, err error) bool {
+	if err == nil {
+		return status >= http.StatusInternalServerError
+	}
+	var he *HTTPError
+	if errors.As(err, &he) {
+		return he.Code >= http.StatusInternalServerError
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+package pisces
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	now := time.Now()
+	var transitions []string
+
+	b := NewCircuitBreaker(CircuitBreakerConfig{
+		ConsecutiveFailures: 2,
+		OpenTimeout:         time.Minute,
+		OnStateChange: func(from, to CircuitState) {
+			transitions = append(transitions, from.String()+">"+to.String())
+		},
+	})
+	b.now = func() time.Time { return now }
+
+	status := http.StatusInternalServerError
+	e := New()
+	e.GET("/", func(c *Context) error {
+		return c.NoContent(status)
+	}, b.Middleware())
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		status  int
+		code    int
+		state   CircuitState
+	}{
+		{"first failure", 0, http.StatusInternalServerError, http.StatusInternalServerError, CircuitClosed},
+		{"second failure trips", 0, http.StatusInternalServerError, http.StatusInternalServerError, CircuitOpen},
+		{"open rejects", 0, http.StatusOK, http.StatusServiceUnavailable, CircuitOpen},
+		{"failed probe reopens", time.Minute, http.StatusBadGateway, http.StatusBadGateway, CircuitOpen},
+		{"successful probe closes", time.Minute, http.StatusOK, http.StatusOK, CircuitClosed},
+		{"client errors are not failures", 0, http.StatusNotFound, http.StatusNotFound, CircuitClosed},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		status = step.status
+		w := performRequest(e, http.MethodGet, "/")
+		if w.Code != step.code {
+			t.Fatalf("%s: got status %d, want %d", step.name, w.Code, step.code)
+		}
+		if w.Code == http.StatusServiceUnavailable && w.Header().Get("Retry-After") != "60" {
+			t.Errorf("%s: got Retry-After %q", step.name, w.Header().Get("Retry-After"))
+		}
+		if state := b.State(); state != step.state {
+			t.Fatalf("%s: got state %s, want %s", step.name, state, step.state)
+		}
+	}
+
+	want := []string{"closed>open", "open>half-open", "half-open>open", "open>half-open", "half-open>closed"}
+	if len(transitions) != len(want) {
+		t.Fatalf("got transitions %v, want %v", transitions, want)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Fatalf("got transitions %v, want %v", transitions, want)
+		}
+	}
+	if s := b.Snapshot(); s.Rejected != 1 || s.Requests != 1 || s.Failures != 0 {
+		t.Errorf("got snapshot %+v", s)
+	}
+}
+
+func TestCircuitBreakerFailureRate(t *testing.T) {
+	b := NewCircuitBreaker(CircuitBreakerConfig{
+		ConsecutiveFailures: -1,
+		FailureRate:         0.5,
+		MinRequests:         4,
+	})
+
+	fail := false
+	e := New()
+	e.GET("/", func(c *Context) error {
+		if fail {
+			return ErrInternalServerError
+		}
+		return c.NoContent(http.StatusOK)
+	}, b.Middleware())
+
+	for i, f := range []bool{false, true, false, true} {
+		fail = f
+		performRequest(e, http.MethodGet, "/")
+		want := CircuitClosed
+		if i == 3 {
+			want = CircuitOpen
+		}
+		if state := b.State(); state != want {
+			t.Fatalf("request %d: got state %s, want %s", i, state, want)
+		}
+	}
+}
+
+func TestCircuitBreakerRecordsTheStatusOfTheResponse(t *testing.T) {
+	buffered := NewCircuitBreaker(CircuitBreakerConfig{ConsecutiveFailures: 1})
+	throttled := NewCircuitBreaker(CircuitBreakerConfig{
+		ConsecutiveFailures: 1,
+		IsFailure: func(c *Context, status int, err error) bool {
+			return status == http.StatusTooManyRequests
+		},
+	})
+
+	e := New()
+	// ETag buffers the response, the status is not written to the connection yet when the
+	// breaker records it
+	e.Use(ETag())
+	e.GET("/buffered", func(c *Context) error {
+		return c.Data(http.StatusInternalServerError, "text/plain", []byte("failed"))
+	}, buffered.Middleware())
+	e.GET("/throttled", func(c *Context) error {
+		return ErrTooManyRequests
+	}, throttled.Middleware())
+
+	if w := performRequest(e, http.MethodGet, "/buffered"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d", w.Code)
+	}
+	if state := buffered.State(); state != CircuitOpen {
+		t.Errorf("got state %s for a buffered server error, want %s", state, CircuitOpen)
+	}
+
+	if w := performRequest(e, http.MethodGet, "/throttled"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d", w.Code)
+	}
+	if state := throttled.State(); state != CircuitOpen {
+		t.Errorf("got state %s, IsFailure did not get the status of the error", state)
+	}
+}
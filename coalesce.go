@@ -0,0 +1,201 @@
+package pisces
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xdatk/pisces/internal/constant"
+)
+
+// CoalesceConfig defines the config for Coalesce middleware.
+type CoalesceConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+
+	// KeyFunc returns the key identical requests share, default the path and the sorted query.
+	KeyFunc func(*Context) string
+
+	// OptOutHeader is the request header which, when present, runs the request on its own,
+	// default X-No-Coalesce.
+	OptOutHeader string
+
+	// MaxWait is how long a request waits for the response of the identical request in flight
+	// before running on its own, default 0 waits until it completes or the request is canceled.
+	MaxWait time.Duration
+
+	// Credentialed also coalesces the requests with an Authorization or Cookie header,
+	// which run on their own by default. KeyFunc must then tell their clients apart.
+	Credentialed bool
+}
+
+// DefaultCoalesceConfig is the default Coalesce middleware config.
+var DefaultCoalesceConfig = CoalesceConfig{
+	Skipper: DefaultSkipper,
+	KeyFunc: func(c *Context) string {
+		return cacheKey(c, nil)
+	},
+	OptOutHeader: "X-No-Coalesce",
+}
+
+// Coalesce returns a middleware which collapses the concurrent GET requests with the same key
+// into one execution of the next handlers. Its response is buffered and replayed to every
+// waiting request, without its Set-Cookie header, so the handlers must not depend on who
+// the client is nor stream. Requests with credentials are not collapsed,
+// see CoalesceConfig.Credentialed. Waiting requests run on their own if the response varies
+// on a header they differ by, or if the client of the first request went away.
+func Coalesce() MiddlewareFunc {
+	return CoalesceWithConfig(DefaultCoalesceConfig)
+}
+
+// CoalesceWithConfig returns a Coalesce middleware with config, see Coalesce.
+func CoalesceWithConfig(config CoalesceConfig) MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultCoalesceConfig.Skipper
+	}
+	if config.KeyFunc == nil {
+		config.KeyFunc = DefaultCoalesceConfig.KeyFunc
+	}
+	if config.OptOutHeader == "" {
+		config.OptOutHeader = DefaultCoalesceConfig.OptOutHeader
+	}
+
+	var mu sync.Mutex
+	calls := make(map[string]*coalesceCall)
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if config.Skipper(c) || c.Method() != http.MethodGet || c.IsWebSocket() {
+				return next(c)
+			}
+			if _, ok := c.GetHeader(config.OptOutHeader); ok {
+				return next(c)
+			}
+			if !config.Credentialed && (c.Header(constant.HeaderAuthorization) != "" || c.Header(constant.HeaderCookie) != "") {
+				return next(c)
+			}
+
+			key := config.KeyFunc(c)
+
+			mu.Lock()
+			if call, ok := calls[key]; ok {
+				mu.Unlock()
+				if call.wait(c, config.MaxWait) && call.matches(c) {
+					return call.replay(c)
+				}
+				return next(c)
+			}
+			call := &coalesceCall{done: make(chan struct{})}
+			calls[key] = call
+			mu.Unlock()
+
+			defer func() {
+				mu.Lock()
+				delete(calls, key)
+				mu.Unlock()
+				// followers run on their own if the handlers panicked
+				close(call.done)
+			}()
+
+			w := c.Writer
+			outer := w.Header().Clone()
+			bw := newBufferWriter(outer)
+			c.Writer = bw
+			defer func() {
+				c.Writer = w
+			}()
+			err := next(c)
+
+			// the response of a leader whose client went away may be cut short, the followers
+			// then run on their own
+			if c.Request.Context().Err() == nil {
+				call.vary = make(map[string]string)
+				for name := range varyHeaders(bw.header) {
+					call.vary[name] = strings.Join(c.Request.Header.Values(name), ", ")
+				}
+				call.err = err
+				call.wroteHeader = bw.wroteHeader
+				call.status = bw.status
+				call.body = bw.body.Bytes()
+				call.header = bw.header.Clone()
+				removeOuterHeader(call.header, outer)
+				// the cookies are the leader's own
+				call.header.Del(constant.HeaderSetCookie)
+				call.ok = true
+			}
+
+			if !bw.wroteHeader {
+				bw.copyHeaderTo(w.Header())
+				return err
+			}
+			if werr := bw.writeTo(w); err == nil {
+				err = werr
+			}
+			return err
+		}
+	}
+}
+
+// coalesceCall is an execution of the handlers shared by identical requests.
+type coalesceCall struct {
+	done chan struct{}
+
+	// set before done is closed
+	ok          bool
+	vary        map[string]string
+	err         error
+	wroteHeader bool
+	status      int
+	header      http.Header
+	body        []byte
+}
+
+// wait reports whether the call completed within maxWait and can be replayed.
+func (call *coalesceCall) wait(c *Context, maxWait time.Duration) bool {
+	var timeout <-chan time.Time
+	if maxWait > 0 {
+		timer := time.NewTimer(maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-call.done:
+		return call.ok
+	case <-timeout:
+		return false
+	case <-c.Request.Context().Done():
+		return false
+	}
+}
+
+// matches reports whether c has the values of the leader's request for the headers its
+// response varies on.
+func (call *coalesceCall) matches(c *Context) bool {
+	for name, value := range call.vary {
+		if name == "*" || strings.Join(c.Request.Header.Values(name), ", ") != value {
+			return false
+		}
+	}
+	return true
+}
+
+// replay writes the response of the call, which headers are added to the ones of c.
+func (call *coalesceCall) replay(c *Context) error {
+	header := c.Writer.Header()
+	for k, v := range call.header {
+		header[k] = append([]string(nil), v...)
+	}
+	if !call.wroteHeader {
+		return call.err
+	}
+
+	c.Writer.WriteHeader(call.status)
+	if len(call.body) > 0 {
+		if _, err := c.Writer.Write(call.body); err != nil && call.err == nil {
+			return err
+		}
+	}
+	return call.err
+}
@@ -0,0 +1,215 @@
+package pisces
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// coalesceFollowers sends n requests while the one of leader is in flight, and returns
+// their responses once unblock is closed. prepare, if not nil, modifies the requests.
+func coalesceFollowers(e *Engine, n int, entered <-chan struct{}, unblock chan struct{}, prepare func(r *http.Request, leader bool)) []*httptest.ResponseRecorder {
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, n+1)
+	serve := func(i int) {
+		defer wg.Done()
+		r := httptest.NewRequest(http.MethodGet, "/?a=1&b=2", nil)
+		if prepare != nil {
+			prepare(r, i == 0)
+		}
+		responses[i] = httptest.NewRecorder()
+		e.ServeHTTP(responses[i], r)
+	}
+
+	wg.Add(n + 1)
+	go serve(0)
+	<-entered
+	for i := 1; i <= n; i++ {
+		go serve(i)
+	}
+	// let the followers find the call in flight
+	time.Sleep(50 * time.Millisecond)
+	close(unblock)
+	wg.Wait()
+	return responses
+}
+
+func TestCoalesce(t *testing.T) {
+	var calls int32
+	entered := make(chan struct{}, 10)
+	unblock := make(chan struct{})
+
+	e := New()
+	e.GET("/", func(c *Context) error {
+		atomic.AddInt32(&calls, 1)
+		entered <- struct{}{}
+		<-unblock
+		c.SetCookie(&http.Cookie{Name: "session", Value: "leader"})
+		c.SetHeader("X-Handler", "1")
+		return c.Text(http.StatusCreated, "shared")
+	}, Coalesce())
+
+	responses := coalesceFollowers(e, 4, entered, unblock, nil)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("got %d calls, want 1", n)
+	}
+	for i, w := range responses {
+		if w.Code != http.StatusCreated || w.Body.String() != "shared" || w.Header().Get("X-Handler") != "1" {
+			t.Errorf("response %d: got %d %q", i, w.Code, w.Body.String())
+		}
+		if cookie := w.Header().Get("Set-Cookie"); (i == 0) != (cookie != "") {
+			t.Errorf("response %d: got Set-Cookie %q", i, cookie)
+		}
+	}
+}
+
+func TestCoalesceSharesErrors(t *testing.T) {
+	var calls int32
+	entered := make(chan struct{}, 10)
+	unblock := make(chan struct{})
+
+	e := New()
+	e.GET("/", func(c *Context) error {
+		atomic.AddInt32(&calls, 1)
+		entered <- struct{}{}
+		<-unblock
+		c.SetHeader("Retry-After", "5")
+		return ErrServiceUnavailable
+	}, Coalesce())
+
+	responses := coalesceFollowers(e, 3, entered, unblock, nil)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("got %d calls, want 1", n)
+	}
+	for i, w := range responses {
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "5" {
+			t.Errorf("response %d: got %d with Retry-After %q", i, w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestCoalesceLeaderPanic(t *testing.T) {
+	var calls int32
+	entered := make(chan struct{}, 10)
+	unblock := make(chan struct{})
+
+	e := New()
+	e.Use(RecoverWithConfig(RecoverConfig{Reporter: func(*Context, *PanicError) {}}))
+	e.GET("/", func(c *Context) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			entered <- struct{}{}
+			<-unblock
+			panic("leader")
+		}
+		return c.Text(http.StatusOK, "alone")
+	}, Coalesce())
+
+	responses := coalesceFollowers(e, 3, entered, unblock, nil)
+	if n := atomic.LoadInt32(&calls); n != 4 {
+		t.Fatalf("got %d calls, want the followers to run on their own", n)
+	}
+	if responses[0].Code != http.StatusInternalServerError {
+		t.Errorf("leader: got status %d", responses[0].Code)
+	}
+	for i, w := range responses[1:] {
+		if w.Code != http.StatusOK || w.Body.String() != "alone" {
+			t.Errorf("follower %d: got %d %q", i, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestCoalesceSkipsCredentialedRequests(t *testing.T) {
+	for _, header := range []http.Header{{"Authorization": {"Bearer x"}}, {"Cookie": {"session=x"}}} {
+		var calls int32
+		entered := make(chan struct{}, 10)
+		unblock := make(chan struct{})
+
+		e := New()
+		e.GET("/", func(c *Context) error {
+			atomic.AddInt32(&calls, 1)
+			entered <- struct{}{}
+			<-unblock
+			return c.NoContent(http.StatusOK)
+		}, Coalesce())
+
+		coalesceFollowers(e, 2, entered, unblock, func(r *http.Request, _ bool) {
+			for k, v := range header {
+				r.Header[k] = v
+			}
+		})
+		if n := atomic.LoadInt32(&calls); n != 3 {
+			t.Errorf("%v: got %d calls, want every request to run", header, n)
+		}
+	}
+}
+
+func TestCoalesceVary(t *testing.T) {
+	var calls int32
+	entered := make(chan struct{}, 10)
+	unblock := make(chan struct{})
+
+	e := New()
+	e.Use(Coalesce(), CompressWithConfig(CompressConfig{MinLength: 1}))
+	e.GET("/", func(c *Context) error {
+		atomic.AddInt32(&calls, 1)
+		entered <- struct{}{}
+		<-unblock
+		return c.Data(http.StatusOK, "text/plain", []byte("body"))
+	})
+
+	responses := coalesceFollowers(e, 2, entered, unblock, func(r *http.Request, leader bool) {
+		if leader {
+			r.Header.Set("Accept-Encoding", "gzip")
+		}
+	})
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Fatalf("got %d calls, want the followers to run on their own", n)
+	}
+	if responses[0].Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("leader: got Content-Encoding %q", responses[0].Header().Get("Content-Encoding"))
+	}
+	for i, w := range responses[1:] {
+		if w.Header().Get("Content-Encoding") != "" || w.Body.String() != "body" {
+			t.Errorf("follower %d: got %q with Content-Encoding %q", i, w.Body.String(), w.Header().Get("Content-Encoding"))
+		}
+	}
+}
+
+func TestCoalesceLeaderCanceled(t *testing.T) {
+	var calls int32
+	entered := make(chan struct{}, 10)
+	unblock := make(chan struct{})
+	var cancel context.CancelFunc
+
+	e := New()
+	e.GET("/", func(c *Context) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			entered <- struct{}{}
+			<-unblock
+			// the client of the leader goes away
+			cancel()
+			return c.Request.Context().Err()
+		}
+		return c.Text(http.StatusOK, "alone")
+	}, Coalesce())
+
+	responses := coalesceFollowers(e, 2, entered, unblock, func(r *http.Request, leader bool) {
+		if leader {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(r.Context())
+			*r = *r.WithContext(ctx)
+		}
+	})
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Fatalf("got %d calls, want the followers to run on their own", n)
+	}
+	for i, w := range responses[1:] {
+		if w.Code != http.StatusOK || w.Body.String() != "alone" {
+			t.Errorf("follower %d: got %d %q", i, w.Code, w.Body.String())
+		}
+	}
+}
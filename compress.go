@@ -0,0 +1,341 @@
+package pisces
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/xdatk/pisces/internal/constant"
+	"github.com/xdatk/pisces/internal/util"
+)
+
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+
+	defaultCompressMinLength = 1024
+)
+
+// CompressConfig defines the config for Compress middleware.
+type CompressConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+
+	// Level is the compression level, default gzip.DefaultCompression.
+	Level int
+
+	// MinLength is the minimum body size, in bytes, for a response to be compressed, default 1024.
+	// Flushed responses are compressed regardless of their size.
+	MinLength int
+
+	// ExcludedContentTypes are the Content-Type prefixes of responses which are not compressed,
+	// default a list of already compressed images, audio, video, fonts and archives.
+	ExcludedContentTypes []string
+}
+
+// DefaultCompressConfig is the default Compress middleware config.
+var DefaultCompressConfig = CompressConfig{
+	Skipper:   DefaultSkipper,
+	Level:     gzip.DefaultCompression,
+	MinLength: defaultCompressMinLength,
+	ExcludedContentTypes: []string{
+		"image/png",
+		"image/jpeg",
+		"image/gif",
+		"image/webp",
+		"image/avif",
+		"video/",
+		"audio/",
+		"font/woff",
+		"application/zip",
+		"application/gzip",
+		"application/x-gzip",
+		"application/x-bzip2",
+		"application/x-xz",
+		"application/x-7z-compressed",
+		"application/x-rar-compressed",
+		"application/zstd",
+	},
+}
+
+// Compress returns a middleware which compresses the response body with gzip or deflate,
+// according to the Accept-Encoding header of the request.
+func Compress() MiddlewareFunc {
+	return CompressWithConfig(DefaultCompressConfig)
+}
+
+// CompressWithConfig returns a Compress middleware with config, see Compress.
+func CompressWithConfig(config CompressConfig) MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultCompressConfig.Skipper
+	}
+	if config.Level == 0 {
+		config.Level = DefaultCompressConfig.Level
+	}
+	if config.MinLength <= 0 {
+		config.MinLength = DefaultCompressConfig.MinLength
+	}
+	if config.ExcludedContentTypes == nil {
+		config.ExcludedContentTypes = DefaultCompressConfig.ExcludedContentTypes
+	}
+
+	pools := map[string]*sync.Pool{
+		encodingGzip: {
+			New: func() interface{} {
+				w, err := gzip.NewWriterLevel(io.Discard, config.Level)
+				if err != nil {
+					panic(err)
+				}
+				return w
+			},
+		},
+		encodingDeflate: {
+			New: func() interface{} {
+				w, err := zlib.NewWriterLevel(io.Discard, config.Level)
+				if err != nil {
+					panic(err)
+				}
+				return w
+			},
+		},
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if config.Skipper(c) || c.Method() == http.MethodHead || c.IsWebSocket() {
+				return next(c)
+			}
+
+			util.AddVary(c.Writer.Header(), constant.HeaderAcceptEncoding)
+
+			encoding := negotiateEncoding(c.Header(constant.HeaderAcceptEncoding))
+			if encoding == "" {
+				return next(c)
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: c.Writer,
+				config:         &config,
+				encoding:       encoding,
+				pool:           pools[encoding],
+			}
+			c.Writer = cw
+			defer func() {
+				c.Writer = cw.ResponseWriter
+			}()
+
+			err := next(c)
+			if cerr := cw.close(); err == nil {
+				err = cerr
+			}
+			return err
+		}
+	}
+}
+
+// compressor is implemented by gzip.Writer and zlib.Writer.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// compressWriter holds the response until MinLength bytes are written or it is flushed,
+// then decides whether to compress it from its headers.
+type compressWriter struct {
+	ResponseWriter
+	config   *CompressConfig
+	encoding string
+	pool     *sync.Pool
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	writer      compressor
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided || w.wroteHeader {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	w.status = code
+	w.wroteHeader = true
+
+	if !bodyAllowedForStatus(code) {
+		_ = w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.config.MinLength {
+			return len(p), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if w.writer != nil {
+		return w.writer.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// committed reports whether the handler wrote the response, which may still be held.
+func (w *compressWriter) committed() bool {
+	return w.wroteHeader
+}
+
+// Flush implements the http.Flusher interface, the response is compressed from now on
+// if its headers allow it.
+func (w *compressWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		_ = w.decide(true)
+	}
+	if w.writer != nil {
+		_ = w.writer.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decide writes the status code and the held body, compressing it if compress is true
+// and the response headers allow it.
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+
+	header := w.Header()
+	if compress {
+		if header.Get(constant.HeaderContentType) == "" && len(w.buf) > 0 {
+			header.Set(constant.HeaderContentType, http.DetectContentType(w.buf))
+		}
+		compress = w.status != http.StatusPartialContent &&
+			header.Get(constant.HeaderContentEncoding) == "" &&
+			header.Get(constant.HeaderContentRange) == "" &&
+			w.compressible(header.Get(constant.HeaderContentType))
+	}
+
+	if compress {
+		header.Set(constant.HeaderContentEncoding, w.encoding)
+		header.Del(constant.HeaderContentLength)
+		// the compressed bytes differ from the ones a strong ETag was computed for
+		if etag := header.Get(constant.HeaderETag); strings.HasPrefix(etag, `"`) {
+			header.Set(constant.HeaderETag, "W/"+etag)
+		}
+		w.writer = w.pool.Get().(compressor)
+		w.writer.Reset(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.writer != nil {
+		_, err := w.writer.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+func (w *compressWriter) compressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, excluded := range w.config.ExcludedContentTypes {
+		if strings.HasPrefix(contentType, excluded) {
+			return false
+		}
+	}
+	return true
+}
+
+// close writes what is still held and finishes the compressed stream.
+// Nothing is written if the handler wrote nothing, so the error handler still can.
+func (w *compressWriter) close() error {
+	if !w.decided {
+		if !w.wroteHeader && len(w.buf) == 0 {
+			return nil
+		}
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+
+	if w.writer == nil {
+		return nil
+	}
+
+	err := w.writer.Close()
+	w.writer.Reset(io.Discard)
+	w.pool.Put(w.writer)
+	w.writer = nil
+	return err
+}
+
+// negotiateEncoding returns the preferred encoding of the Accept-Encoding header among
+// gzip and deflate, or an empty string if neither is acceptable.
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	gzipQ, deflateQ, anyQ := -1.0, -1.0, -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name := part
+		q := 1.0
+		if i := strings.IndexByte(part, ';'); i >= 0 {
+			name = part[:i]
+			param := strings.TrimSpace(part[i+1:])
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					v = 0
+				}
+				q = v
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case encodingGzip, "x-gzip":
+			gzipQ = q
+		case encodingDeflate:
+			deflateQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+
+	if gzipQ < 0 {
+		gzipQ = anyQ
+	}
+	if deflateQ < 0 {
+		deflateQ = anyQ
+	}
+
+	switch {
+	case gzipQ > 0 && gzipQ >= deflateQ:
+		return encodingGzip
+	case deflateQ > 0:
+		return encodingDeflate
+	default:
+		return ""
+	}
+}
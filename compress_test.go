@@ -0,0 +1,208 @@
+package pisces
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"x-gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"br", ""},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"*", "gzip"},
+		{"*;q=0.5, gzip;q=0", "deflate"},
+		{"GZIP;q=1.0", "gzip"},
+		{"gzip;q=bad", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.acceptEncoding); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("hello pisces ", 200)
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		handler        HandlerFunc
+		encoding       string
+		body           string
+	}{
+		{
+			name:           "gzip",
+			acceptEncoding: "gzip",
+			handler: func(c *Context) error {
+				return c.Text(http.StatusCreated, large)
+			},
+			encoding: "gzip",
+			body:     large,
+		},
+		{
+			name:           "deflate",
+			acceptEncoding: "deflate",
+			handler: func(c *Context) error {
+				return c.Text(http.StatusOK, large)
+			},
+			encoding: "deflate",
+			body:     large,
+		},
+		{
+			name: "not accepted",
+			handler: func(c *Context) error {
+				return c.Text(http.StatusOK, large)
+			},
+			body: large,
+		},
+		{
+			name:           "below min length",
+			acceptEncoding: "gzip",
+			handler: func(c *Context) error {
+				return c.Text(http.StatusOK, "small")
+			},
+			body: "small",
+		},
+		{
+			name:           "already encoded",
+			acceptEncoding: "gzip",
+			handler: func(c *Context) error {
+				c.SetHeader("Content-Encoding", "br")
+				return c.Data(http.StatusOK, "text/plain", []byte(large))
+			},
+			encoding: "br",
+			body:     large,
+		},
+		{
+			name:           "excluded content type",
+			acceptEncoding: "gzip",
+			handler: func(c *Context) error {
+				return c.Data(http.StatusOK, "image/png", []byte(large))
+			},
+			body: large,
+		},
+		{
+			name:           "range",
+			acceptEncoding: "gzip",
+			handler: func(c *Context) error {
+				c.SetHeader("Content-Range", "bytes 0-2599/5000")
+				return c.Text(http.StatusPartialContent, large)
+			},
+			body: large,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := New()
+			e.Use(Compress())
+			e.GET("/", tt.handler)
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+
+			if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Fatalf("got encoding %q, want %q", got, tt.encoding)
+			}
+			if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("got Vary %q", got)
+			}
+			if body := decodeBody(t, tt.encoding, w.Body); body != tt.body {
+				t.Errorf("got body of %d bytes, want %d", len(body), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestCompressKeepsStatusAndWeakensETag(t *testing.T) {
+	e := New()
+	e.Use(Compress())
+	e.GET("/", func(c *Context) error {
+		c.SetHeader("ETag", `"v1"`)
+		return c.Text(http.StatusCreated, strings.Repeat("a", 2048))
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+
+	if w.Code != http.StatusCreated || w.Header().Get("ETag") != `W/"v1"` || w.Header().Get("Content-Length") != "" {
+		t.Errorf("got %d, ETag %q and Content-Length %q", w.Code, w.Header().Get("ETag"), w.Header().Get("Content-Length"))
+	}
+}
+
+func TestCompressLeavesErrorsToTheErrorHandler(t *testing.T) {
+	e := New()
+	e.Use(Compress())
+	e.GET("/", func(c *Context) error {
+		return ErrNotFound
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Encoding") != "" || !strings.Contains(w.Body.String(), "Not Found") {
+		t.Errorf("got %d %q with encoding %q", w.Code, w.Body.String(), w.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestRenderWritesNothingOnError(t *testing.T) {
+	e := New()
+	e.GET("/", func(c *Context) error {
+		return c.JSON(http.StatusCreated, make(chan int))
+	})
+	e.GET("/no-content", func(c *Context) error {
+		return c.Text(http.StatusNoContent, "body")
+	})
+
+	w := performRequest(e, http.MethodGet, "/")
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d for data that can not be rendered", w.Code)
+	}
+
+	w = performRequest(e, http.MethodGet, "/no-content")
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "body") {
+		t.Errorf("got %d %q for a body with a status that does not allow one", w.Code, w.Body.String())
+	}
+}
+
+func decodeBody(t *testing.T, encoding string, body io.Reader) string {
+	t.Helper()
+
+	var err error
+	switch encoding {
+	case "gzip":
+		body, err = gzip.NewReader(body)
+	case "deflate":
+		body, err = zlib.NewReader(body)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,237 @@
+package pisces
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	defaultConcurrencyBackoff = 0.9
+)
+
+// ConcurrencyLimitConfig defines the config for ConcurrencyLimiter.
+type ConcurrencyLimitConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+
+	// Limit is the number of requests allowed to run at once, required.
+	// It is the initial limit when Adaptive is set.
+	Limit int
+
+	// MaxQueue is the number of requests allowed to wait for a slot, default 0 sheds
+	// every request beyond Limit.
+	MaxQueue int
+
+	// QueueTimeout is how long a request waits for a slot before being shed,
+	// default 0 waits until the request is canceled.
+	QueueTimeout time.Duration
+
+	// Adaptive enables AIMD adjustment of the limit: it grows by one after Limit requests
+	// complete within LatencyThreshold, and is multiplied by Backoff when one does not.
+	// The requests already running when the limit backs off do not make it back off again,
+	// so it backs off at most once per LatencyThreshold.
+	Adaptive bool
+
+	// MinLimit is the lower bound of the adaptive limit, default 1.
+	MinLimit int
+
+	// MaxLimit is the upper bound of the adaptive limit, default 10 times Limit.
+	MaxLimit int
+
+	// LatencyThreshold is the latency above which the adaptive limit backs off, required with Adaptive.
+	LatencyThreshold time.Duration
+
+	// Backoff is the factor the adaptive limit is multiplied by on slow requests, default 0.9.
+	Backoff float64
+}
+
+// ConcurrencyLimiterStats is a snapshot of the state of a ConcurrencyLimiter.
+type ConcurrencyLimiterStats struct {
+	Limit      int
+	InFlight   int
+	QueueDepth int
+	// Shed is the number of requests rejected since the limiter was created.
+	Shed uint64
+}
+
+// ConcurrencyLimiter caps the number of requests running at once, with a bounded FIFO wait queue.
+// A limiter is shared by every route its middleware is added to, create one per route
+// or group to limit them separately.
+type ConcurrencyLimiter struct {
+	config ConcurrencyLimitConfig
+
+	mu        sync.Mutex
+	limit     int
+	inFlight  int
+	waiters   list.List
+	succeeded int
+	backoffAt time.Time
+	shed      uint64
+
+	// now is replaced by tests.
+	now func() time.Time
+}
+
+// NewConcurrencyLimiter returns a ConcurrencyLimiter configured by config.
+func NewConcurrencyLimiter(config ConcurrencyLimitConfig) *ConcurrencyLimiter {
+	if config.Limit <= 0 {
+		panic("concurrency limit must be positive")
+	}
+	if config.Skipper == nil {
+		config.Skipper = DefaultSkipper
+	}
+	if config.MaxQueue < 0 {
+		config.MaxQueue = 0
+	}
+	if config.Adaptive {
+		if config.LatencyThreshold <= 0 {
+			panic("adaptive concurrency limit requires a latency threshold")
+		}
+		if config.MinLimit <= 0 {
+			config.MinLimit = 1
+		}
+		if config.MaxLimit <= 0 {
+			config.MaxLimit = 10 * config.Limit
+		}
+		if config.Backoff <= 0 || config.Backoff >= 1 {
+			config.Backoff = defaultConcurrencyBackoff
+		}
+	}
+
+	return &ConcurrencyLimiter{
+		config: config,
+		limit:  config.Limit,
+		now:    time.Now,
+	}
+}
+
+// Middleware returns a middleware which runs the next handlers within the limit,
+// requests which cannot get a slot fail with ErrServiceUnavailable.
+func (l *ConcurrencyLimiter) Middleware() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if l.config.Skipper(c) {
+				return next(c)
+			}
+
+			if err := l.acquire(c); err != nil {
+				return err
+			}
+
+			start := l.now()
+			defer l.release(start)
+
+			return next(c)
+		}
+	}
+}
+
+// Stats returns a snapshot of the state of the limiter.
+func (l *ConcurrencyLimiter) Stats() ConcurrencyLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return ConcurrencyLimiterStats{
+		Limit:      l.limit,
+		InFlight:   l.inFlight,
+		QueueDepth: l.waiters.Len(),
+		Shed:       l.shed,
+	}
+}
+
+// QueueDepth returns the number of requests waiting for a slot.
+func (l *ConcurrencyLimiter) QueueDepth() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waiters.Len()
+}
+
+func (l *ConcurrencyLimiter) acquire(c *Context) error {
+	l.mu.Lock()
+	if l.inFlight < l.limit && l.waiters.Len() == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	if l.waiters.Len() >= l.config.MaxQueue {
+		l.shed++
+		l.mu.Unlock()
+		return ErrServiceUnavailable
+	}
+	ready := make(chan struct{})
+	elem := l.waiters.PushBack(ready)
+	l.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if l.config.QueueTimeout > 0 {
+		timer := time.NewTimer(l.config.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-timeout:
+		err = ErrServiceUnavailable
+	case <-c.Request.Context().Done():
+		err = NewHTTPError(ErrServiceUnavailable.Code, ErrServiceUnavailable.Message).SetInternal(c.Request.Context().Err())
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// the slot was granted while giving up
+		return nil
+	default:
+		l.waiters.Remove(elem)
+		l.shed++
+		return err
+	}
+}
+
+func (l *ConcurrencyLimiter) release(start time.Time) {
+	end := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	if l.config.Adaptive {
+		l.adapt(start, end)
+	}
+
+	for l.inFlight < l.limit && l.waiters.Len() > 0 {
+		ready := l.waiters.Remove(l.waiters.Front()).(chan struct{})
+		l.inFlight++
+		close(ready)
+	}
+}
+
+// adapt adjusts the limit from a request which ran from start to end.
+func (l *ConcurrencyLimiter) adapt(start, end time.Time) {
+	if end.Sub(start) > l.config.LatencyThreshold {
+		// the requests started before the last back off were slowed by the same load
+		if start.Before(l.backoffAt) {
+			return
+		}
+		l.backoffAt = end
+		l.succeeded = 0
+		limit := int(float64(l.limit) * l.config.Backoff)
+		if limit < l.config.MinLimit {
+			limit = l.config.MinLimit
+		}
+		l.limit = limit
+		return
+	}
+
+	l.succeeded++
+	if l.succeeded >= l.limit {
+		l.succeeded = 0
+		if l.limit < l.config.MaxLimit {
+			l.limit++
+		}
+	}
+}
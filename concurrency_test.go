@@ -0,0 +1,130 @@
+package pisces
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiterQueues(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyLimitConfig{Limit: 1, MaxQueue: 1})
+	started := make(chan struct{}, 2)
+	unblock := make(chan struct{})
+
+	e := New()
+	e.GET("/", func(c *Context) error {
+		started <- struct{}{}
+		<-unblock
+		return c.NoContent(http.StatusOK)
+	}, l.Middleware())
+
+	codes := make(chan int, 2)
+	serve := func() {
+		codes <- performRequest(e, http.MethodGet, "/").Code
+	}
+
+	go serve()
+	<-started
+	go serve()
+	for l.QueueDepth() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if w := performRequest(e, http.MethodGet, "/"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d with a full queue", w.Code)
+	}
+	if stats := l.Stats(); stats != (ConcurrencyLimiterStats{Limit: 1, InFlight: 1, QueueDepth: 1, Shed: 1}) {
+		t.Errorf("got stats %+v", stats)
+	}
+
+	close(unblock)
+	for i := 0; i < 2; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("got status %d", code)
+		}
+	}
+	if stats := l.Stats(); stats.InFlight != 0 || stats.QueueDepth != 0 {
+		t.Errorf("got stats %+v once served", stats)
+	}
+}
+
+func TestConcurrencyLimiterQueueTimeout(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyLimitConfig{Limit: 1, MaxQueue: 1, QueueTimeout: 10 * time.Millisecond})
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	e := New()
+	e.GET("/", func(c *Context) error {
+		<-unblock
+		return nil
+	}, l.Middleware())
+
+	go performRequest(e, http.MethodGet, "/")
+	for l.Stats().InFlight != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if w := performRequest(e, http.MethodGet, "/"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d after the queue timeout", w.Code)
+	}
+	if stats := l.Stats(); stats.QueueDepth != 0 || stats.Shed != 1 {
+		t.Errorf("got stats %+v", stats)
+	}
+}
+
+func TestConcurrencyLimiterAdapts(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyLimitConfig{Limit: 10, Adaptive: true, LatencyThreshold: 100 * time.Millisecond, Backoff: 0.5})
+	t0 := time.Unix(1000, 0)
+	ms := func(n int) time.Time {
+		return t0.Add(time.Duration(n) * time.Millisecond)
+	}
+
+	// the requests slowed by the same load back off once
+	l.adapt(ms(0), ms(200))
+	l.adapt(ms(10), ms(210))
+	l.adapt(ms(150), ms(300))
+	if l.limit != 5 {
+		t.Fatalf("got limit %d after one round of slow requests, want 5", l.limit)
+	}
+
+	// a request started after the back off still being slow backs off again
+	l.adapt(ms(250), ms(400))
+	if l.limit != 2 {
+		t.Fatalf("got limit %d after the next slow request, want 2", l.limit)
+	}
+	l.adapt(ms(450), ms(600))
+	if l.limit != 1 {
+		t.Fatalf("got limit %d, want the minimum 1", l.limit)
+	}
+
+	// the limit grows by one per limit fast requests
+	for want := 2; want <= 4; want++ {
+		for i := 0; i < want-1; i++ {
+			l.adapt(ms(700), ms(710))
+		}
+		if l.limit != want {
+			t.Fatalf("got limit %d, want %d", l.limit, want)
+		}
+	}
+}
+
+func TestConcurrencyLimiterAdaptsThroughMiddleware(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyLimitConfig{Limit: 4, Adaptive: true, LatencyThreshold: 100 * time.Millisecond})
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	l.now = clock.now
+
+	e := New()
+	e.GET("/", func(c *Context) error {
+		clock.advance(time.Second)
+		return nil
+	}, l.Middleware())
+
+	performRequest(e, http.MethodGet, "/")
+	if stats := l.Stats(); stats.Limit != 3 {
+		t.Errorf("got limit %d after a slow request, want 3", stats.Limit)
+	}
+	performRequest(e, http.MethodGet, "/")
+	if stats := l.Stats(); stats.Limit != 2 {
+		t.Errorf("got limit %d after another slow request, want 2", stats.Limit)
+	}
+}
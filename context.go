@@ -1,9 +1,11 @@
 package pisces
 
 import (
+	"context"
 	"fmt"
 	"github.com/xdatk/pisces/binding"
 	"github.com/xdatk/pisces/internal/constant"
+	"github.com/xdatk/pisces/internal/util"
 	"github.com/xdatk/pisces/render"
 	"io"
 	"mime/multipart"
@@ -12,8 +14,13 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+var _ context.Context = (*Context)(nil)
+
 type Context struct {
 	engine *Engine
 
@@ -27,6 +34,16 @@ type Context struct {
 	fullPath    string
 	handlerName string
 	handler     HandlerFunc
+	requestID   string
+	principal   interface{}
+	cspNonce    string
+
+	// mu protects keys.
+	mu   sync.RWMutex
+	keys map[string]interface{}
+
+	// released is set once the request is served, see Done.
+	released int32
 
 	queryCache              url.Values
 	headerCache             http.Header
@@ -48,6 +65,11 @@ func (c *Context) reset(writer http.ResponseWriter, request *http.Request) {
 	c.fullPath = ""
 	c.handlerName = ""
 	c.handler = nil
+	c.requestID = ""
+	c.principal = nil
+	c.cspNonce = ""
+	c.keys = nil
+	atomic.StoreInt32(&c.released, 0)
 
 	c.queryCache = nil
 	c.cookieCache = nil
@@ -57,6 +79,225 @@ func (c *Context) reset(writer http.ResponseWriter, request *http.Request) {
 	c.parseMultipartFormError = nil
 }
 
+// clone returns a copy of the Context writing its response to w, for the handlers
+// running on another goroutine. The copy is never returned to the pool.
+func (c *Context) clone(w ResponseWriter) *Context {
+	cc := &Context{
+		engine:      c.engine,
+		Request:     c.Request,
+		params:      append(Params(nil), c.params...),
+		fullPath:    c.fullPath,
+		handlerName: c.handlerName,
+		handler:     c.handler,
+		requestID:   c.requestID,
+		principal:   c.principal,
+		cspNonce:    c.cspNonce,
+	}
+	cc.paramsMem = &cc.params
+	cc.writerMem.reset(w)
+	cc.Writer = &cc.writerMem
+
+	c.mu.RLock()
+	if c.keys != nil {
+		cc.keys = make(map[string]interface{}, len(c.keys))
+		for k, v := range c.keys {
+			cc.keys[k] = v
+		}
+	}
+	c.mu.RUnlock()
+
+	return cc
+}
+
+/************************************/
+/********* Key/Value Store **********/
+/************************************/
+
+// Set stores a new key/value pair exclusively for this request.
+func (c *Context) Set(key string, value interface{}) {
+	c.mu.Lock()
+	if c.keys == nil {
+		c.keys = make(map[string]interface{})
+	}
+	c.keys[key] = value
+	c.mu.Unlock()
+}
+
+// Get returns the value for the given key, ie: (value, true).
+// If the value does not exist it returns (nil, false).
+func (c *Context) Get(key string) (value interface{}, exists bool) {
+	c.mu.RLock()
+	value, exists = c.keys[key]
+	c.mu.RUnlock()
+	return
+}
+
+// MustGet returns the value for the given key if it exists, otherwise it panics.
+func (c *Context) MustGet(key string) interface{} {
+	if value, exists := c.Get(key); exists {
+		return value
+	}
+	panic("key \"" + key + "\" does not exist")
+}
+
+// GetString returns the value associated with the key as a string.
+func (c *Context) GetString(key string) (s string) {
+	if val, ok := c.Get(key); ok && val != nil {
+		s, _ = val.(string)
+	}
+	return
+}
+
+// GetBool returns the value associated with the key as a boolean.
+func (c *Context) GetBool(key string) (b bool) {
+	if val, ok := c.Get(key); ok && val != nil {
+		b, _ = val.(bool)
+	}
+	return
+}
+
+// GetInt returns the value associated with the key as an integer.
+func (c *Context) GetInt(key string) (i int) {
+	if val, ok := c.Get(key); ok && val != nil {
+		i, _ = val.(int)
+	}
+	return
+}
+
+// GetInt64 returns the value associated with the key as an integer.
+func (c *Context) GetInt64(key string) (i64 int64) {
+	if val, ok := c.Get(key); ok && val != nil {
+		i64, _ = val.(int64)
+	}
+	return
+}
+
+// GetUint returns the value associated with the key as an unsigned integer.
+func (c *Context) GetUint(key string) (ui uint) {
+	if val, ok := c.Get(key); ok && val != nil {
+		ui, _ = val.(uint)
+	}
+	return
+}
+
+// GetUint64 returns the value associated with the key as an unsigned integer.
+func (c *Context) GetUint64(key string) (ui64 uint64) {
+	if val, ok := c.Get(key); ok && val != nil {
+		ui64, _ = val.(uint64)
+	}
+	return
+}
+
+// GetFloat64 returns the value associated with the key as a float64.
+func (c *Context) GetFloat64(key string) (f64 float64) {
+	if val, ok := c.Get(key); ok && val != nil {
+		f64, _ = val.(float64)
+	}
+	return
+}
+
+// GetTime returns the value associated with the key as time.
+func (c *Context) GetTime(key string) (t time.Time) {
+	if val, ok := c.Get(key); ok && val != nil {
+		t, _ = val.(time.Time)
+	}
+	return
+}
+
+// GetDuration returns the value associated with the key as a duration.
+func (c *Context) GetDuration(key string) (d time.Duration) {
+	if val, ok := c.Get(key); ok && val != nil {
+		d, _ = val.(time.Duration)
+	}
+	return
+}
+
+// GetStringSlice returns the value associated with the key as a slice of strings.
+func (c *Context) GetStringSlice(key string) (ss []string) {
+	if val, ok := c.Get(key); ok && val != nil {
+		ss, _ = val.([]string)
+	}
+	return
+}
+
+// GetStringMap returns the value associated with the key as a map of interfaces.
+func (c *Context) GetStringMap(key string) (sm map[string]interface{}) {
+	if val, ok := c.Get(key); ok && val != nil {
+		sm, _ = val.(map[string]interface{})
+	}
+	return
+}
+
+// GetStringMapString returns the value associated with the key as a map of strings.
+func (c *Context) GetStringMapString(key string) (sms map[string]string) {
+	if val, ok := c.Get(key); ok && val != nil {
+		sms, _ = val.(map[string]string)
+	}
+	return
+}
+
+/************************************/
+/********* context.Context **********/
+/************************************/
+
+// closedChan is the done channel of a released Context.
+var closedChan = make(chan struct{})
+
+func init() {
+	close(closedChan)
+}
+
+// release marks the Context as served, before it is put back in the pool.
+func (c *Context) release() {
+	atomic.StoreInt32(&c.released, 1)
+}
+
+func (c *Context) isReleased() bool {
+	return atomic.LoadInt32(&c.released) != 0
+}
+
+// Deadline returns the deadline of the request's context, see context.Context.
+func (c *Context) Deadline() (deadline time.Time, ok bool) {
+	if c.isReleased() {
+		return
+	}
+	return c.Request.Context().Deadline()
+}
+
+// Done returns the done channel of the request's context, see context.Context.
+//
+// The Context is reused once the request is served, it must not be retained by work
+// outliving the request. As a guard, once served and until it is reused, the Context is
+// done: Done returns a closed channel, Err returns context.Canceled and Value returns nil.
+func (c *Context) Done() <-chan struct{} {
+	if c.isReleased() {
+		return closedChan
+	}
+	return c.Request.Context().Done()
+}
+
+// Err returns the error of the request's context, see context.Context.
+func (c *Context) Err() error {
+	if c.isReleased() {
+		return context.Canceled
+	}
+	return c.Request.Context().Err()
+}
+
+// Value returns the value stored with Set if key is a string and exists,
+// otherwise the value of the request's context, see context.Context.
+func (c *Context) Value(key interface{}) interface{} {
+	if c.isReleased() {
+		return nil
+	}
+	if k, ok := key.(string); ok {
+		if val, exists := c.Get(k); exists {
+			return val
+		}
+	}
+	return c.Request.Context().Value(key)
+}
+
 /************************************/
 /************ Input Data ************/
 /************************************/
@@ -72,6 +313,36 @@ func (c *Context) Handler() HandlerFunc {
 	return c.handler
 }
 
+// FullPath returns the matched route template, for example "/users/:id".
+// It returns an empty string if no route matched the request.
+func (c *Context) FullPath() string {
+	return c.fullPath
+}
+
+// RequestID returns the ID of the request set by RequestID middleware,
+// otherwise it returns an empty string `("")`.
+func (c *Context) RequestID() string {
+	return c.requestID
+}
+
+// Principal returns the authenticated principal set by an authentication middleware,
+// such as BasicAuth or KeyAuth, otherwise it returns nil.
+func (c *Context) Principal() interface{} {
+	return c.principal
+}
+
+// SetPrincipal sets the authenticated principal of the request.
+func (c *Context) SetPrincipal(principal interface{}) {
+	c.principal = principal
+}
+
+// CSPNonce returns the Content-Security-Policy nonce of the request set by Secure middleware,
+// to be used in the nonce attribute of inline scripts and styles, otherwise it returns an
+// empty string `("")`.
+func (c *Context) CSPNonce() string {
+	return c.cspNonce
+}
+
 // Path returns the url path of the request.
 func (c *Context) Path() string {
 	return c.Request.URL.Path
@@ -167,25 +438,61 @@ func (c *Context) GetHeaders() http.Header {
 }
 
 // Scheme returns the HTTP protocol scheme, `http` or `https`.
+// If the request comes from a trusted proxy, see WithTrustedProxies, the scheme is the one
+// forwarded along the client address, see WithIPHeader: the proto of the Forwarded element
+// of the client, or the X-Forwarded-Proto value the proxy talking to the client appended.
 func (c *Context) Scheme() string {
 	if c.IsTLS() {
 		return "https"
 	}
-	if scheme := c.Header(constant.HeaderXForwardedProto); scheme != "" {
-		return scheme
-	}
-	if scheme := c.Header(constant.HeaderXForwardedProtocol); scheme != "" {
-		return scheme
+	if !c.fromTrustedProxy() {
+		return "http"
 	}
-	if ssl := c.Header(constant.HeaderXForwardedSsl); ssl == "on" {
-		return "https"
+
+	scheme := ""
+	switch c.engine.ipHeader {
+	case constant.HeaderForwarded:
+		if elements := util.ParseForwarded(c.HeaderValues(constant.HeaderForwarded)); len(elements) > 0 {
+			scheme = elements[c.forwardedClient(elements)].Proto
+		}
+	default:
+		if protos := headerList(c.HeaderValues(constant.HeaderXForwardedProto)); len(protos) > 0 {
+			// every hop appending its value, the client one is at the index of the client
+			// address, otherwise the proxy replaced the header
+			i := len(protos) - 1
+			if c.engine.ipHeader == constant.HeaderXForwardedFor {
+				if ips := headerList(c.HeaderValues(constant.HeaderXForwardedFor)); len(ips) == len(protos) {
+					if client := c.forwardedForClient(ips); client >= 0 {
+						i = client
+					}
+				}
+			}
+			scheme = strings.ToLower(protos[i])
+		}
 	}
-	if scheme := c.Header(constant.HeaderXUrlScheme); scheme != "" {
+
+	if validScheme(scheme) {
 		return scheme
 	}
 	return "http"
 }
 
+func validScheme(scheme string) bool {
+	return scheme == "http" || scheme == "https"
+}
+
+// headerList returns the comma separated elements of the header values, trimmed.
+func headerList(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	list := strings.Split(strings.Join(values, ","), ",")
+	for i := range list {
+		list[i] = strings.TrimSpace(list[i])
+	}
+	return list
+}
+
 // ContentType returns the Content-Type header of the request.
 func (c *Context) ContentType() string {
 	s := c.Header(constant.HeaderContentType)
@@ -209,16 +516,77 @@ func (c *Context) IsWebSocket() bool {
 	return strings.ToLower(upgrade) == "websocket"
 }
 
-// RealIP returns the client's network address
+// RemoteIP returns the IP address of the direct peer of the connection.
+func (c *Context) RemoteIP() string {
+	ip, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return c.Request.RemoteAddr
+	}
+	return ip
+}
+
+// RealIP returns the client's network address. If the request comes from a trusted proxy,
+// see WithTrustedProxies, it is read from the single header set by WithIPHeader, default
+// X-Forwarded-For. The forwarded addresses are walked from right to left skipping the
+// trusted proxies, the other forwarding headers are ignored.
 func (c *Context) RealIP() string {
-	if ip := c.Header(constant.HeaderXForwardedFor); ip != "" {
-		return strings.Split(ip, ", ")[0]
+	if !c.fromTrustedProxy() {
+		return c.RemoteIP()
+	}
+
+	switch c.engine.ipHeader {
+	case constant.HeaderForwarded:
+		if elements := util.ParseForwarded(c.HeaderValues(constant.HeaderForwarded)); len(elements) > 0 {
+			if ip := elements[c.forwardedClient(elements)].For; net.ParseIP(ip) != nil {
+				return ip
+			}
+		}
+	case constant.HeaderXRealIP:
+		if ip := strings.TrimSpace(c.Header(constant.HeaderXRealIP)); net.ParseIP(ip) != nil {
+			return ip
+		}
+	default:
+		ips := headerList(c.HeaderValues(constant.HeaderXForwardedFor))
+		if client := c.forwardedForClient(ips); client >= 0 {
+			return ips[client]
+		}
+	}
+
+	return c.RemoteIP()
+}
+
+// fromTrustedProxy reports whether the direct peer is a trusted proxy.
+func (c *Context) fromTrustedProxy() bool {
+	return c.engine.isTrustedProxy(net.ParseIP(c.RemoteIP()))
+}
+
+// forwardedClient returns the index of the Forwarded element describing the client,
+// that is the rightmost element whose "for" is not a trusted proxy.
+func (c *Context) forwardedClient(elements []util.ForwardedElement) int {
+	for i := len(elements) - 1; i >= 0; i-- {
+		if !c.engine.isTrustedProxy(net.ParseIP(elements[i].For)) {
+			return i
+		}
 	}
-	if ip := c.Header(constant.HeaderXRealIP); ip != "" {
-		return ip
+	return 0
+}
+
+// forwardedForClient returns the index of the rightmost address of ips which is not a trusted
+// proxy, or of the leftmost one if they are all trusted. It stops at the first invalid address
+// and returns the index of the closest valid hop instead, -1 if there is none.
+func (c *Context) forwardedForClient(ips []string) int {
+	client := -1
+	for i := len(ips) - 1; i >= 0; i-- {
+		ip := net.ParseIP(ips[i])
+		if ip == nil {
+			return client
+		}
+		client = i
+		if !c.engine.isTrustedProxy(ip) {
+			return client
+		}
 	}
-	ra, _, _ := net.SplitHostPort(c.Request.RemoteAddr)
-	return ra
+	return client
 }
 
 // GetHeaders returns the header of the request.
@@ -409,7 +777,7 @@ func (c *Context) FormFile(name string) (*multipart.FileHeader, error) {
 func (c *Context) GetMultipartForms() (*multipart.Form, error) {
 	if c.parseMultipartForm == nil {
 		b := false
-		err := c.Request.ParseMultipartForm(32 << 20)
+		err := c.Request.ParseMultipartForm(c.engine.maxMultipartMemory)
 		if err != nil {
 			c.parseMultipartFormError = err
 		} else {
@@ -532,8 +900,11 @@ func (c *Context) BindBodyUseCustom(binder binding.Body, obj interface{}) error
 	return binder.Bind(c.Body(), obj)
 }
 
-// Validate is validate obj struct
+// Validate is validate obj struct, it returns ErrValidatorNotRegistered if the engine has no Validator.
 func (c *Context) Validate(obj interface{}) error {
+	if c.engine.validator == nil {
+		return ErrValidatorNotRegistered
+	}
 	return c.engine.validator.Validate(obj)
 }
 
@@ -541,11 +912,12 @@ func (c *Context) Validate(obj interface{}) error {
 /******** RESPONSE RENDERING ********/
 /************************************/
 
-func (c *Context) setStatus(code int) {
-	c.writerMem.Status = code
-}
-
+// IsCommitted reports whether the response was written, through c.Writer and the writers
+// the middlewares wrapped it in, even if a middleware still holds it in a buffer.
 func (c *Context) IsCommitted() bool {
+	if w, ok := c.Writer.(committer); ok {
+		return w.committed()
+	}
 	return c.writerMem.Committed
 }
 
@@ -579,22 +951,23 @@ func (c *Context) NoContent(code int) error {
 }
 
 func (c *Context) renderCodeAndContentType(code int, contentType string) error {
-	if (code >= 100 && code <= 199) || code == http.StatusNoContent || code == http.StatusNotModified {
+	if !bodyAllowedForStatus(code) {
 		return fmt.Errorf("unsupport")
 	}
 
 	c.setContentType(contentType)
-	c.setStatus(code)
+	c.Writer.WriteHeader(code)
 	return nil
 }
 
-// Render writes the response headers and calls Render to render data.
+// Render calls Render to render data, then writes the response headers and the data.
+// Nothing is written if the data can not be rendered.
 func (c *Context) Render(code int, r render.Render) (err error) {
-	err = c.renderCodeAndContentType(code, r.ContentType())
+	body, err := r.Render()
 	if err != nil {
 		return err
 	}
-	body, err := r.Render()
+	err = c.renderCodeAndContentType(code, r.ContentType())
 	if err != nil {
 		return err
 	}
@@ -691,6 +1064,33 @@ func (c *Context) Redirect(code int, url string) error {
 	return nil
 }
 
+// Conditional sets the ETag and Last-Modified headers of the response, when they are not empty,
+// and evaluates the conditional headers of the request against them. It returns true if the
+// request has been answered, the handler must then return err: nil after a 304 Not Modified
+// response, or ErrPreconditionFailed. It should be called before doing the work the response
+// depends on, for example:
+//
+//	if done, err := c.Conditional(etag, updatedAt); done {
+//		return err
+//	}
+func (c *Context) Conditional(etag string, lastModified time.Time) (done bool, err error) {
+	header := c.Writer.Header()
+	if etag != "" {
+		header.Set(constant.HeaderETag, etag)
+	}
+	if !lastModified.IsZero() {
+		header.Set(constant.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	switch checkPreconditions(c.Request, etag, lastModified) {
+	case http.StatusNotModified:
+		return true, c.NoContent(http.StatusNotModified)
+	case http.StatusPreconditionFailed:
+		return true, ErrPreconditionFailed
+	}
+	return false, nil
+}
+
 func (c *Context) Error(err error) {
 	c.engine.errorHandler(c, err)
 }
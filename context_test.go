@@ -0,0 +1,260 @@
+package pisces
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestContextKeys(t *testing.T) {
+	now := time.Now()
+	values := map[string]interface{}{
+		"string":          "s",
+		"bool":            true,
+		"int":             1,
+		"int64":           int64(2),
+		"uint":            uint(3),
+		"uint64":          uint64(4),
+		"float64":         5.5,
+		"time":            now,
+		"duration":        time.Second,
+		"stringSlice":     []string{"a"},
+		"stringMap":       map[string]interface{}{"a": 1},
+		"stringMapString": map[string]string{"a": "b"},
+	}
+
+	c := &Context{}
+	for k, v := range values {
+		c.Set(k, v)
+	}
+
+	got := map[string]interface{}{
+		"string":          c.GetString("string"),
+		"bool":            c.GetBool("bool"),
+		"int":             c.GetInt("int"),
+		"int64":           c.GetInt64("int64"),
+		"uint":            c.GetUint("uint"),
+		"uint64":          c.GetUint64("uint64"),
+		"float64":         c.GetFloat64("float64"),
+		"time":            c.GetTime("time"),
+		"duration":        c.GetDuration("duration"),
+		"stringSlice":     c.GetStringSlice("stringSlice"),
+		"stringMap":       c.GetStringMap("stringMap"),
+		"stringMapString": c.GetStringMapString("stringMapString"),
+	}
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("got %v, want %v", got, values)
+	}
+
+	// the typed getters return the zero value for missing keys and other types
+	if c.GetString("int") != "" || c.GetInt("missing") != 0 || c.GetTime("string") != (time.Time{}) {
+		t.Error("typed getters did not return zero values")
+	}
+	if v, ok := c.Get("missing"); v != nil || ok {
+		t.Errorf("got %v, %v for a missing key", v, ok)
+	}
+	if c.MustGet("int") != 1 {
+		t.Error("MustGet returned the wrong value")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("MustGet did not panic on a missing key")
+			}
+		}()
+		c.MustGet("missing")
+	}()
+}
+
+type contextTestKey struct{}
+
+func TestContextAsContext(t *testing.T) {
+	var retained *Context
+	e := New()
+	e.GET("/", func(c *Context) error {
+		retained = c
+		c.Set("user", "alice")
+
+		if c.Value("user") != "alice" || c.Value(contextTestKey{}) != "parent" {
+			t.Errorf("got values %v and %v", c.Value("user"), c.Value(contextTestKey{}))
+		}
+		if c.Err() != nil {
+			t.Errorf("got error %v while serving", c.Err())
+		}
+		if _, ok := c.Deadline(); !ok {
+			t.Error("lost the deadline of the request's context")
+		}
+
+		ctx, cancel := context.WithCancel(c)
+		cancel()
+		<-ctx.Done()
+		if c.Err() != nil {
+			t.Error("canceling a child canceled the Context")
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), contextTestKey{}, "parent"), time.Minute)
+	defer cancel()
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	e.ServeHTTP(httptest.NewRecorder(), r)
+
+	select {
+	case <-retained.Done():
+	default:
+		t.Error("a served Context is not done")
+	}
+	if retained.Err() != context.Canceled || retained.Value("user") != nil || retained.Value(contextTestKey{}) != nil {
+		t.Errorf("a served Context still exposes the request: %v, %v", retained.Err(), retained.Value("user"))
+	}
+	if _, ok := retained.Deadline(); ok {
+		t.Error("a served Context still has a deadline")
+	}
+}
+
+func TestContextCloneKeys(t *testing.T) {
+	c := &Context{}
+	c.Set("a", 1)
+
+	cc := c.clone(newBufferWriter(nil))
+	cc.Set("b", 2)
+
+	if cc.GetInt("a") != 1 {
+		t.Error("clone lost the keys")
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Error("the keys set on the clone leaked to the original")
+	}
+}
+
+func TestContextRealIPAndScheme(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		remoteAddr string
+		header     map[string]string
+		ip         string
+		scheme     string
+	}{
+		{
+			name:       "untrusted peer",
+			remoteAddr: "203.0.113.7:1234",
+			header:     map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Forwarded-Proto": "https", "X-Real-IP": "1.2.3.4"},
+			ip:         "203.0.113.7",
+			scheme:     "http",
+		},
+		{
+			name:       "trusted proxy appends X-Forwarded-For",
+			opts:       []Option{WithTrustedProxies("10.0.0.1")},
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.7", "X-Forwarded-Proto": "https, http"},
+			ip:         "203.0.113.7",
+			scheme:     "http",
+		},
+		{
+			name:       "trusted proxy replaces X-Forwarded-Proto",
+			opts:       []Option{WithTrustedProxies("10.0.0.1")},
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.7", "X-Forwarded-Proto": "https"},
+			ip:         "203.0.113.7",
+			scheme:     "https",
+		},
+		{
+			name:       "trusted hops are skipped",
+			opts:       []Option{WithTrustedProxies("10.0.0.0/8")},
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.7, 10.0.0.2", "X-Forwarded-Proto": "http, https, http"},
+			ip:         "203.0.113.7",
+			scheme:     "https",
+		},
+		{
+			name:       "invalid hop stops the walk",
+			opts:       []Option{WithTrustedProxies("10.0.0.0/8")},
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string]string{"X-Forwarded-For": "203.0.113.7, garbage, 10.0.0.2"},
+			ip:         "10.0.0.2",
+			scheme:     "http",
+		},
+		{
+			name:       "spoofed Forwarded is ignored with X-Forwarded-For",
+			opts:       []Option{WithTrustedProxies("10.0.0.1")},
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string]string{"Forwarded": "for=1.2.3.4;proto=https", "X-Forwarded-For": "203.0.113.7", "X-Forwarded-Proto": "http"},
+			ip:         "203.0.113.7",
+			scheme:     "http",
+		},
+		{
+			name:       "spoofed X-Real-IP is ignored with X-Forwarded-For",
+			opts:       []Option{WithTrustedProxies("10.0.0.1")},
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string]string{"X-Real-IP": "1.2.3.4"},
+			ip:         "10.0.0.1",
+			scheme:     "http",
+		},
+		{
+			name:       "Forwarded",
+			opts:       []Option{WithTrustedProxies("10.0.0.1"), WithIPHeader("forwarded")},
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string]string{"Forwarded": `for=1.2.3.4;proto=http, for="[2001:db8::1]:443";proto=https`, "X-Forwarded-For": "1.2.3.4"},
+			ip:         "2001:db8::1",
+			scheme:     "https",
+		},
+		{
+			name:       "spoofed X-Forwarded-For is ignored with Forwarded",
+			opts:       []Option{WithTrustedProxies("10.0.0.1"), WithIPHeader("Forwarded")},
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Forwarded-Proto": "https"},
+			ip:         "10.0.0.1",
+			scheme:     "http",
+		},
+		{
+			name:       "X-Real-IP",
+			opts:       []Option{WithTrustedProxies("10.0.0.1"), WithIPHeader("X-Real-IP")},
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string]string{"X-Real-IP": "203.0.113.7", "X-Forwarded-For": "1.2.3.4", "X-Forwarded-Proto": "https"},
+			ip:         "203.0.113.7",
+			scheme:     "https",
+		},
+		{
+			name:       "invalid scheme",
+			opts:       []Option{WithTrustedProxies("10.0.0.1")},
+			remoteAddr: "10.0.0.1:1234",
+			header:     map[string]string{"X-Forwarded-Proto": "javascript"},
+			ip:         "10.0.0.1",
+			scheme:     "http",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ip, scheme string
+			e := New(tt.opts...)
+			e.GET("/", func(c *Context) error {
+				ip, scheme = c.RealIP(), c.Scheme()
+				return nil
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			e.ServeHTTP(httptest.NewRecorder(), r)
+
+			if ip != tt.ip || scheme != tt.scheme {
+				t.Errorf("got %s and %s, want %s and %s", ip, scheme, tt.ip, tt.scheme)
+			}
+		})
+	}
+}
+
+func TestWithIPHeaderPanicsOnOtherHeaders(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+	WithIPHeader("X-Client-IP")
+}
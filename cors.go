@@ -0,0 +1,213 @@
+package pisces
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/xdatk/pisces/internal/constant"
+	"github.com/xdatk/pisces/internal/util"
+)
+
+// CORSConfig defines the config for CORS middleware.
+type CORSConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+
+	// AllowOrigins is a list of origins that may access the resource. An origin is either
+	// "*", an exact origin such as "https://example.com", or a wildcard subdomain origin
+	// such as "https://*.example.com". Default ["*"] unless AllowOriginFunc is set.
+	AllowOrigins []string
+
+	// AllowOriginFunc reports whether the origin may access the resource,
+	// it is consulted when the origin does not match AllowOrigins.
+	AllowOriginFunc func(origin string) bool
+
+	// AllowMethods is the list of methods allowed when accessing the resource,
+	// default GET, HEAD, PUT, PATCH, POST, DELETE.
+	AllowMethods []string
+
+	// AllowHeaders is the list of request headers allowed in the actual request,
+	// default reflects the preflight's Access-Control-Request-Headers.
+	AllowHeaders []string
+
+	// ExposeHeaders is the list of response headers clients are allowed to access.
+	ExposeHeaders []string
+
+	// AllowCredentials indicates whether the response can be exposed when credentials are included,
+	// the origin is then echoed. It can not be combined with the "*" origin, nor with a wildcard
+	// origin which is not followed by a domain such as "https://*", which would let any site
+	// read the credentialed responses.
+	AllowCredentials bool
+
+	// MaxAge indicates how long, in seconds, the preflight results can be cached.
+	// Zero omits the header, a negative value sends "0".
+	MaxAge int
+}
+
+// DefaultCORSConfig is the default CORS middleware config.
+var DefaultCORSConfig = CORSConfig{
+	Skipper:      DefaultSkipper,
+	AllowOrigins: []string{"*"},
+	AllowMethods: []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodPut,
+		http.MethodPatch,
+		http.MethodPost,
+		http.MethodDelete,
+	},
+}
+
+// CORS returns a Cross-Origin Resource Sharing middleware which allows any origin.
+//
+// Preflight requests are answered by the middleware itself, so it should be added to the
+// engine for preflights of paths without an OPTIONS route to reach it.
+func CORS() MiddlewareFunc {
+	return CORSWithConfig(DefaultCORSConfig)
+}
+
+// CORSWithConfig returns a CORS middleware with config, see CORS.
+// It panics if AllowCredentials is set while any origin, or any host, is allowed.
+func CORSWithConfig(config CORSConfig) MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultCORSConfig.Skipper
+	}
+	if len(config.AllowOrigins) == 0 && config.AllowOriginFunc == nil {
+		config.AllowOrigins = DefaultCORSConfig.AllowOrigins
+	}
+	if len(config.AllowMethods) == 0 {
+		config.AllowMethods = DefaultCORSConfig.AllowMethods
+	}
+
+	allowAll := false
+	anyHost := false
+	exact := make(map[string]struct{}, len(config.AllowOrigins))
+	var wildcards [][2]string
+	for _, o := range config.AllowOrigins {
+		o = strings.ToLower(o)
+		switch i := strings.Index(o, "*"); {
+		case o == "*":
+			allowAll = true
+		case i >= 0:
+			suffix := o[i+1:]
+			if len(suffix) < 2 || suffix[0] != '.' {
+				// the wildcard covers the whole host
+				anyHost = true
+			}
+			wildcards = append(wildcards, [2]string{o[:i], suffix})
+		default:
+			exact[o] = struct{}{}
+		}
+	}
+
+	if (allowAll || anyHost) && config.AllowCredentials {
+		panic("cors credentials must not be allowed for any origin")
+	}
+
+	allowOrigin := func(origin string) bool {
+		if allowAll {
+			return true
+		}
+
+		o := strings.ToLower(origin)
+		if _, ok := exact[o]; ok {
+			return true
+		}
+		for _, w := range wildcards {
+			if matchSubdomain(o, w[0], w[1]) {
+				return true
+			}
+		}
+
+		return config.AllowOriginFunc != nil && config.AllowOriginFunc(origin)
+	}
+
+	allowMethods := strings.Join(config.AllowMethods, ",")
+	allowHeaders := strings.Join(config.AllowHeaders, ",")
+	exposeHeaders := strings.Join(config.ExposeHeaders, ",")
+	maxAge := ""
+	if config.MaxAge > 0 {
+		maxAge = strconv.Itoa(config.MaxAge)
+	} else if config.MaxAge < 0 {
+		maxAge = "0"
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			header := c.Writer.Header()
+			origin := c.Header(constant.HeaderOrigin)
+			preflight := c.Method() == http.MethodOptions && c.Header(constant.HeaderAccessControlRequestMethod) != ""
+
+			util.AddVary(header, constant.HeaderOrigin)
+			if preflight {
+				util.AddVary(header, constant.HeaderAccessControlRequestMethod)
+				util.AddVary(header, constant.HeaderAccessControlRequestHeaders)
+			}
+
+			if origin == "" {
+				return next(c)
+			}
+
+			if !allowOrigin(origin) {
+				if preflight {
+					return c.NoContent(http.StatusNoContent)
+				}
+				return next(c)
+			}
+
+			if allowAll {
+				header.Set(constant.HeaderAccessControlAllowOrigin, "*")
+			} else {
+				header.Set(constant.HeaderAccessControlAllowOrigin, origin)
+			}
+			if config.AllowCredentials {
+				header.Set(constant.HeaderAccessControlAllowCredentials, "true")
+			}
+
+			if !preflight {
+				if exposeHeaders != "" {
+					header.Set(constant.HeaderAccessControlExposeHeaders, exposeHeaders)
+				}
+				return next(c)
+			}
+
+			header.Set(constant.HeaderAccessControlAllowMethods, allowMethods)
+			if allowHeaders != "" {
+				header.Set(constant.HeaderAccessControlAllowHeaders, allowHeaders)
+			} else if h := c.Header(constant.HeaderAccessControlRequestHeaders); h != "" {
+				header.Set(constant.HeaderAccessControlAllowHeaders, h)
+			}
+			if maxAge != "" {
+				header.Set(constant.HeaderAccessControlMaxAge, maxAge)
+			}
+
+			return c.NoContent(http.StatusNoContent)
+		}
+	}
+}
+
+// matchSubdomain reports whether origin is prefix + one or more subdomain labels + suffix,
+// for example "https://api.example.com" for the pattern "https://*.example.com".
+func matchSubdomain(origin, prefix, suffix string) bool {
+	if len(origin) <= len(prefix)+len(suffix) ||
+		!strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+
+	sub := origin[len(prefix) : len(origin)-len(suffix)]
+	if sub[0] == '.' || sub[len(sub)-1] == '.' || strings.Contains(sub, "..") {
+		return false
+	}
+	for i := 0; i < len(sub); i++ {
+		c := sub[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,171 @@
+package pisces
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	handler := func(c *Context) error {
+		return c.Text(http.StatusOK, "ok")
+	}
+
+	tests := []struct {
+		name    string
+		config  CORSConfig
+		method  string
+		origin  string
+		request map[string]string
+		code    int
+		want    map[string]string
+	}{
+		{
+			name:   "simple any origin",
+			config: DefaultCORSConfig,
+			method: http.MethodGet,
+			origin: "https://a.example",
+			code:   http.StatusOK,
+			want:   map[string]string{"Access-Control-Allow-Origin": "*", "Vary": "Origin"},
+		},
+		{
+			name:   "no origin",
+			config: DefaultCORSConfig,
+			method: http.MethodGet,
+			code:   http.StatusOK,
+			want:   map[string]string{"Access-Control-Allow-Origin": "", "Vary": "Origin"},
+		},
+		{
+			name:   "simple allowed origin with credentials",
+			config: CORSConfig{AllowOrigins: []string{"https://a.example"}, AllowCredentials: true, ExposeHeaders: []string{"X-Total"}},
+			method: http.MethodGet,
+			origin: "https://a.example",
+			code:   http.StatusOK,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "https://a.example",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "X-Total",
+				"Vary":                             "Origin",
+			},
+		},
+		{
+			name:   "simple disallowed origin",
+			config: CORSConfig{AllowOrigins: []string{"https://a.example"}, AllowCredentials: true},
+			method: http.MethodGet,
+			origin: "https://evil.example",
+			code:   http.StatusOK,
+			want:   map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Credentials": ""},
+		},
+		{
+			name:   "wildcard subdomain",
+			config: CORSConfig{AllowOrigins: []string{"https://*.example.com"}},
+			method: http.MethodGet,
+			origin: "https://api.example.com",
+			code:   http.StatusOK,
+			want:   map[string]string{"Access-Control-Allow-Origin": "https://api.example.com"},
+		},
+		{
+			name:   "wildcard does not match the apex",
+			config: CORSConfig{AllowOrigins: []string{"https://*.example.com"}},
+			method: http.MethodGet,
+			origin: "https://example.com",
+			code:   http.StatusOK,
+			want:   map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:   "origin func with credentials",
+			config: CORSConfig{AllowOriginFunc: func(o string) bool { return o == "https://b.example" }, AllowCredentials: true},
+			method: http.MethodGet,
+			origin: "https://b.example",
+			code:   http.StatusOK,
+			want:   map[string]string{"Access-Control-Allow-Origin": "https://b.example", "Access-Control-Allow-Credentials": "true"},
+		},
+		{
+			name:    "preflight",
+			config:  CORSConfig{AllowOrigins: []string{"https://a.example"}, MaxAge: 600},
+			method:  http.MethodOptions,
+			origin:  "https://a.example",
+			request: map[string]string{"Access-Control-Request-Method": "PUT", "Access-Control-Request-Headers": "X-Custom"},
+			code:    http.StatusNoContent,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "https://a.example",
+				"Access-Control-Allow-Methods": "GET,HEAD,PUT,PATCH,POST,DELETE",
+				"Access-Control-Allow-Headers": "X-Custom",
+				"Access-Control-Max-Age":       "600",
+				"Vary":                         "Origin, Access-Control-Request-Method, Access-Control-Request-Headers",
+			},
+		},
+		{
+			name:    "preflight disallowed origin",
+			config:  CORSConfig{AllowOrigins: []string{"https://a.example"}},
+			method:  http.MethodOptions,
+			origin:  "https://evil.example",
+			request: map[string]string{"Access-Control-Request-Method": "PUT"},
+			code:    http.StatusNoContent,
+			want:    map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := New()
+			e.Use(CORSWithConfig(tt.config))
+			e.GET("/", handler)
+			e.PUT("/", handler)
+
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			for k, v := range tt.request {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Fatalf("got status %d, want %d", w.Code, tt.code)
+			}
+			for k, v := range tt.want {
+				if got := strings.Join(w.Header()[k], ", "); got != v {
+					t.Errorf("got %s %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSCredentialsWithAnyOriginPanics(t *testing.T) {
+	for _, origins := range [][]string{nil, {"*"}, {"https://a.example", "*"}, {"https://*"}, {"https://*:8443"}, {"https://*example.com"}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v: expected a panic", origins)
+				}
+			}()
+			CORSWithConfig(CORSConfig{AllowOrigins: origins, AllowCredentials: true})
+		}()
+	}
+}
+
+func TestCORSCredentialsWithWildcardSubdomain(t *testing.T) {
+	e := New()
+	e.Use(CORSWithConfig(CORSConfig{AllowOrigins: []string{"https://*.example.com"}, AllowCredentials: true}))
+	e.GET("/", func(c *Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	for origin, want := range map[string]string{
+		"https://api.example.com": "https://api.example.com",
+		"https://evil.com":        "",
+		"https://evilexample.com": "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != want {
+			t.Errorf("%s: got Access-Control-Allow-Origin %q, want %q", origin, got, want)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package pisces
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/xdatk/pisces/internal/constant"
+	"github.com/xdatk/pisces/internal/util"
+)
+
+const (
+	defaultCSRFTokenLength = 32
+	defaultCSRFCookieName  = "_csrf"
+	defaultCSRFCookieAge   = 24 * time.Hour
+)
+
+var (
+	// ErrCSRFInvalidToken is returned by CSRF middleware when an unsafe request has no valid token.
+	ErrCSRFInvalidToken = NewHTTPError(http.StatusForbidden, "invalid csrf token")
+	// ErrCSRFInvalidOrigin is returned by CSRF middleware when an unsafe HTTPS request comes
+	// from another origin.
+	ErrCSRFInvalidOrigin = NewHTTPError(http.StatusForbidden, "invalid csrf origin")
+)
+
+// CSRFConfig defines the config for CSRF middleware.
+type CSRFConfig struct {
+	// Skipper defines a function to skip middleware, for example for APIs authenticated
+	// with bearer tokens, which are not sent by browsers on their own.
+	Skipper Skipper
+
+	// TokenLookup is a comma separated list of "<source>:<name>" the token of unsafe requests
+	// is looked up from, in order, default "header:X-CSRF-Token,form:_csrf".
+	// See KeyAuthConfig.KeyLookup for the sources.
+	TokenLookup string
+
+	// Secret signs the tokens with HMAC-SHA256 when set, binding them to the session of
+	// SessionID so a token cannot be planted by a sibling subdomain which can write cookies.
+	// Otherwise the token of the request must only match the one of the cookie,
+	// the double-submit pattern.
+	Secret []byte
+
+	// SessionID returns the identifier of the session of the request, such as the ID of
+	// the session cookie or the authenticated principal, required with Secret. Tokens issued
+	// for another session are replaced, so it must be set by the previous middlewares.
+	SessionID func(*Context) string
+
+	// ContextKey is the key the token is stored under on the Context, default "csrf".
+	ContextKey string
+
+	// CookieName is the name of the token cookie, default "_csrf".
+	CookieName string
+
+	// CookieDomain is the Domain attribute of the token cookie.
+	CookieDomain string
+
+	// CookiePath is the Path attribute of the token cookie, default "/".
+	CookiePath string
+
+	// CookieMaxAge is the lifetime of the token cookie, default 24 hours.
+	CookieMaxAge time.Duration
+
+	// CookieSecure sets the Secure attribute of the token cookie.
+	CookieSecure bool
+
+	// CookieHTTPOnly sets the HttpOnly attribute of the token cookie, the token must then be
+	// read from the Context to be sent back.
+	CookieHTTPOnly bool
+
+	// CookieSameSite is the SameSite attribute of the token cookie, default http.SameSiteLaxMode.
+	CookieSameSite http.SameSite
+
+	// TrustedOrigins are the origins, other than the one of the request, unsafe HTTPS requests
+	// may come from, for example "https://app.example.com".
+	TrustedOrigins []string
+}
+
+// DefaultCSRFConfig is the default CSRF middleware config.
+var DefaultCSRFConfig = CSRFConfig{
+	Skipper:        DefaultSkipper,
+	TokenLookup:    "header:" + constant.HeaderXCSRFToken + ",form:_csrf",
+	ContextKey:     "csrf",
+	CookieName:     defaultCSRFCookieName,
+	CookiePath:     "/",
+	CookieMaxAge:   defaultCSRFCookieAge,
+	CookieSameSite: http.SameSiteLaxMode,
+}
+
+// CSRF returns a Cross-Site Request Forgery protection middleware.
+//
+// A token is issued in a cookie and stored on the Context, the requests with an unsafe method
+// must send it back in the X-CSRF-Token header or the _csrf form field, or they fail with
+// ErrCSRFInvalidToken. Unsafe HTTPS requests must also come from the same origin, per their
+// Origin or Referer header, or they fail with ErrCSRFInvalidOrigin.
+func CSRF() MiddlewareFunc {
+	return CSRFWithConfig(DefaultCSRFConfig)
+}
+
+// CSRFWithConfig returns a CSRF middleware with config, see CSRF.
+func CSRFWithConfig(config CSRFConfig) MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultCSRFConfig.Skipper
+	}
+	if config.TokenLookup == "" {
+		config.TokenLookup = DefaultCSRFConfig.TokenLookup
+	}
+	if config.ContextKey == "" {
+		config.ContextKey = DefaultCSRFConfig.ContextKey
+	}
+	if config.CookieName == "" {
+		config.CookieName = DefaultCSRFConfig.CookieName
+	}
+	if config.CookiePath == "" {
+		config.CookiePath = DefaultCSRFConfig.CookiePath
+	}
+	if config.CookieMaxAge == 0 {
+		config.CookieMaxAge = DefaultCSRFConfig.CookieMaxAge
+	}
+	if config.CookieSameSite == 0 {
+		config.CookieSameSite = DefaultCSRFConfig.CookieSameSite
+	}
+	if len(config.Secret) > 0 && config.SessionID == nil {
+		panic("csrf session id func must not be nil with a secret")
+	}
+
+	extractors, err := createExtractors(config.TokenLookup)
+	if err != nil {
+		panic(err)
+	}
+
+	trustedOrigins := make(map[string]struct{}, len(config.TrustedOrigins))
+	for _, o := range config.TrustedOrigins {
+		trustedOrigins[strings.ToLower(o)] = struct{}{}
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			util.AddVary(c.Writer.Header(), constant.HeaderCookie)
+
+			sessionID := ""
+			if config.SessionID != nil {
+				sessionID = config.SessionID(c)
+			}
+
+			token := ""
+			for _, cookie := range c.GetCookies() {
+				if cookie.Name == config.CookieName {
+					token = cookie.Value
+					break
+				}
+			}
+			if !validCSRFToken(token, sessionID, config.Secret) {
+				token = newCSRFToken(sessionID, config.Secret)
+			}
+
+			switch c.Method() {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			default:
+				if c.Scheme() == "https" && !sameOriginCSRF(c, trustedOrigins) {
+					return ErrCSRFInvalidOrigin
+				}
+
+				sent, err := extractValue(c, extractors)
+				if err != nil {
+					return err
+				}
+				if sent == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+					return ErrCSRFInvalidToken
+				}
+			}
+
+			c.SetCookie(&http.Cookie{
+				Name:     config.CookieName,
+				Value:    token,
+				Path:     config.CookiePath,
+				Domain:   config.CookieDomain,
+				Expires:  time.Now().Add(config.CookieMaxAge),
+				MaxAge:   int(config.CookieMaxAge / time.Second),
+				Secure:   config.CookieSecure,
+				HttpOnly: config.CookieHTTPOnly,
+				SameSite: config.CookieSameSite,
+			})
+			c.Set(config.ContextKey, token)
+
+			return next(c)
+		}
+	}
+}
+
+// newCSRFToken returns a random token, followed by its HMAC with the session ID if secret is set.
+func newCSRFToken(sessionID string, secret []byte) string {
+	b := make([]byte, defaultCSRFTokenLength)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	if len(secret) == 0 {
+		return token
+	}
+	return token + "." + signCSRFToken(token, sessionID, secret)
+}
+
+func signCSRFToken(token, sessionID string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	// the token is base64url encoded, so the separator can not be moved into it
+	mac.Write([]byte(token))
+	mac.Write([]byte{'!'})
+	mac.Write([]byte(sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validCSRFToken reports whether the token of the cookie can be reused for the session.
+func validCSRFToken(token, sessionID string, secret []byte) bool {
+	if len(secret) == 0 {
+		return len(token) == base64.RawURLEncoding.EncodedLen(defaultCSRFTokenLength)
+	}
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return false
+	}
+	return hmac.Equal([]byte(token[i+1:]), []byte(signCSRFToken(token[:i], sessionID, secret)))
+}
+
+// sameOriginCSRF reports whether the Origin, or the Referer if there is none, of the
+// request is the origin of the request itself or a trusted one.
+func sameOriginCSRF(c *Context, trustedOrigins map[string]struct{}) bool {
+	origin := c.Header(constant.HeaderOrigin)
+	if origin == "" || origin == "null" {
+		referer := c.Request.Referer()
+		if referer == "" {
+			return false
+		}
+		u, err := url.Parse(referer)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+
+	origin = strings.ToLower(origin)
+	if origin == "https://"+strings.ToLower(c.Request.Host) {
+		return true
+	}
+	_, ok := trustedOrigins[origin]
+	return ok
+}
@@ -0,0 +1,174 @@
+package pisces
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func csrfEngine(config CSRFConfig) *Engine {
+	e := New()
+	e.Use(CSRFWithConfig(config))
+	handler := func(c *Context) error {
+		return c.Text(http.StatusOK, c.GetString("csrf"))
+	}
+	e.GET("/", handler)
+	e.POST("/", handler)
+	return e
+}
+
+// issueCSRFToken returns the token cookie set on a GET request of session.
+func issueCSRFToken(t *testing.T, e *Engine, session string) string {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Session", session)
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "_csrf" {
+			if cookie.Value != w.Body.String() {
+				t.Fatalf("got cookie %q and context token %q", cookie.Value, w.Body.String())
+			}
+			return cookie.Value
+		}
+	}
+	t.Fatal("no token cookie")
+	return ""
+}
+
+func TestCSRF(t *testing.T) {
+	for _, secret := range [][]byte{nil, []byte("secret")} {
+		config := CSRFConfig{Secret: secret}
+		if secret != nil {
+			config.SessionID = func(c *Context) string {
+				return c.Header("X-Session")
+			}
+		}
+		e := csrfEngine(config)
+		token := issueCSRFToken(t, e, "s1")
+
+		tests := []struct {
+			name   string
+			method string
+			cookie string
+			header string
+			form   string
+			code   int
+		}{
+			{"safe method without token", http.MethodGet, "", "", "", http.StatusOK},
+			{"header", http.MethodPost, token, token, "", http.StatusOK},
+			{"form", http.MethodPost, token, "", token, http.StatusOK},
+			{"no token", http.MethodPost, token, "", "", http.StatusForbidden},
+			{"no cookie", http.MethodPost, "", token, "", http.StatusForbidden},
+			{"mismatch", http.MethodPost, token, token + "x", "", http.StatusForbidden},
+			{"planted cookie", http.MethodPost, "planted", "planted", "", http.StatusForbidden},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				var body string
+				if tt.form != "" {
+					body = url.Values{"_csrf": {tt.form}}.Encode()
+				}
+				r := httptest.NewRequest(tt.method, "/", strings.NewReader(body))
+				r.Header.Set("X-Session", "s1")
+				if tt.form != "" {
+					r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				}
+				if tt.cookie != "" {
+					r.AddCookie(&http.Cookie{Name: "_csrf", Value: tt.cookie})
+				}
+				if tt.header != "" {
+					r.Header.Set("X-CSRF-Token", tt.header)
+				}
+				w := httptest.NewRecorder()
+				e.ServeHTTP(w, r)
+
+				if w.Code != tt.code {
+					t.Errorf("secret %q: got status %d, want %d", secret, w.Code, tt.code)
+				}
+			})
+		}
+	}
+}
+
+func TestCSRFBindsTokensToTheSession(t *testing.T) {
+	e := csrfEngine(CSRFConfig{
+		Secret: []byte("secret"),
+		SessionID: func(c *Context) string {
+			return c.Header("X-Session")
+		},
+	})
+	// a token issued to the attacker's session, planted in the victim's cookies
+	planted := issueCSRFToken(t, e, "attacker")
+
+	post := func(session string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("X-Session", session)
+		r.Header.Set("X-CSRF-Token", planted)
+		r.AddCookie(&http.Cookie{Name: "_csrf", Value: planted})
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := post("attacker"); w.Code != http.StatusOK {
+		t.Fatalf("got status %d in the session of the token", w.Code)
+	}
+	if w := post("victim"); w.Code != http.StatusForbidden {
+		t.Errorf("got status %d in another session", w.Code)
+	}
+	if token := issueCSRFToken(t, e, "victim"); token == planted {
+		t.Error("the token of another session was reused")
+	}
+}
+
+func TestCSRFOrigin(t *testing.T) {
+	e := csrfEngine(CSRFConfig{TrustedOrigins: []string{"https://app.example.com"}})
+	token := issueCSRFToken(t, e, "")
+
+	tests := []struct {
+		name    string
+		origin  string
+		referer string
+		code    int
+	}{
+		{"same origin", "https://example.com", "", http.StatusOK},
+		{"trusted origin", "https://APP.example.com", "", http.StatusOK},
+		{"referer", "", "https://example.com/form", http.StatusOK},
+		{"cross origin", "https://evil.example", "", http.StatusForbidden},
+		{"cross origin referer", "", "https://evil.example/form", http.StatusForbidden},
+		{"no origin", "", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "https://example.com/", nil)
+			r.AddCookie(&http.Cookie{Name: "_csrf", Value: token})
+			r.Header.Set("X-CSRF-Token", token)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Errorf("got status %d, want %d", w.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestCSRFSecretRequiresSessionID(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+	CSRFWithConfig(CSRFConfig{Secret: []byte("secret")})
+}
@@ -0,0 +1,140 @@
+package pisces
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/xdatk/pisces/internal/constant"
+)
+
+const (
+	defaultMaxDecompressedSize = 10 << 20 // 10 MB
+)
+
+// DecompressConfig defines the config for Decompress middleware.
+type DecompressConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+
+	// MaxDecompressedSize is the maximum size, in bytes, of the decompressed request body,
+	// default 10 MB. Reads past it return ErrStatusRequestEntityTooLarge.
+	MaxDecompressedSize int64
+}
+
+// DefaultDecompressConfig is the default Decompress middleware config.
+var DefaultDecompressConfig = DecompressConfig{
+	Skipper:             DefaultSkipper,
+	MaxDecompressedSize: defaultMaxDecompressedSize,
+}
+
+// Decompress returns a middleware which transparently decodes gzip and deflate request bodies,
+// according to their Content-Encoding header. Other encodings fail with ErrUnsupportedMediaType.
+//
+// Add BodyLimit before it to also cap the size of the compressed body.
+func Decompress() MiddlewareFunc {
+	return DecompressWithConfig(DefaultDecompressConfig)
+}
+
+// DecompressWithConfig returns a Decompress middleware with config, see Decompress.
+func DecompressWithConfig(config DecompressConfig) MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultDecompressConfig.Skipper
+	}
+	if config.MaxDecompressedSize <= 0 {
+		config.MaxDecompressedSize = DefaultDecompressConfig.MaxDecompressedSize
+	}
+
+	var pool sync.Pool
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if config.Skipper(c) || c.Request.Body == nil {
+				return next(c)
+			}
+
+			var reader io.ReadCloser
+			switch strings.ToLower(strings.TrimSpace(c.Header(constant.HeaderContentEncoding))) {
+			case "", "identity":
+				return next(c)
+			case encodingGzip, "x-gzip":
+				gr, _ := pool.Get().(*gzip.Reader)
+				if gr == nil {
+					gr = new(gzip.Reader)
+				}
+				if err := gr.Reset(c.Request.Body); err != nil && err != io.EOF {
+					return NewHTTPError(http.StatusBadRequest).SetInternal(err)
+				}
+				defer pool.Put(gr)
+				reader = gr
+			case encodingDeflate:
+				zr, err := zlib.NewReader(c.Request.Body)
+				if err != nil && err != io.EOF {
+					return NewHTTPError(http.StatusBadRequest).SetInternal(err)
+				}
+				if zr != nil {
+					reader = zr
+				}
+			default:
+				return ErrUnsupportedMediaType
+			}
+
+			body := c.Request.Body
+			if reader == nil {
+				reader = io.NopCloser(strings.NewReader(""))
+			}
+
+			r := c.Request
+			r.Body = &decompressedBody{
+				reader:    reader,
+				body:      body,
+				remaining: config.MaxDecompressedSize,
+			}
+			r.ContentLength = -1
+			r.Header.Del(constant.HeaderContentEncoding)
+			r.Header.Del(constant.HeaderContentLength)
+			// runs before the reader is put back in the pool, so the request never holds
+			// a reader another request decodes with
+			defer func() {
+				r.Body = body
+			}()
+
+			return next(c)
+		}
+	}
+}
+
+// decompressedBody reads at most remaining bytes from the decompressing reader,
+// reading past it returns ErrStatusRequestEntityTooLarge.
+type decompressedBody struct {
+	reader    io.ReadCloser
+	body      io.ReadCloser
+	remaining int64
+}
+
+func (b *decompressedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.reader.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = 0
+		return n, ErrStatusRequestEntityTooLarge
+	}
+
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *decompressedBody) Close() error {
+	err := b.reader.Close()
+	if berr := b.body.Close(); err == nil {
+		err = berr
+	}
+	return err
+}
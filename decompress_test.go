@@ -0,0 +1,106 @@
+package pisces
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecompress(t *testing.T) {
+	var gz, zl bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte("hello"))
+	gw.Close()
+	zw := zlib.NewWriter(&zl)
+	zw.Write([]byte("hello"))
+	zw.Close()
+
+	e := New()
+	e.Use(Decompress())
+	e.POST("/", func(c *Context) error {
+		b, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return err
+		}
+		return c.Data(http.StatusOK, "text/plain", b)
+	})
+
+	tests := []struct {
+		encoding string
+		body     []byte
+		code     int
+		want     string
+	}{
+		{"", []byte("plain"), http.StatusOK, "plain"},
+		{"gzip", gz.Bytes(), http.StatusOK, "hello"},
+		{"deflate", zl.Bytes(), http.StatusOK, "hello"},
+		{"gzip", []byte("not gzip"), http.StatusBadRequest, ""},
+		{"br", []byte("x"), http.StatusUnsupportedMediaType, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body))
+		if tt.encoding != "" {
+			r.Header.Set("Content-Encoding", tt.encoding)
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+
+		if w.Code != tt.code || tt.code == http.StatusOK && w.Body.String() != tt.want {
+			t.Errorf("%q: got %d %q, want %d %q", tt.encoding, w.Code, w.Body.String(), tt.code, tt.want)
+		}
+	}
+}
+
+func TestDecompressCapsDecompressedSize(t *testing.T) {
+	// a small request which expands to 1 MB
+	var bomb bytes.Buffer
+	gw := gzip.NewWriter(&bomb)
+	gw.Write(bytes.Repeat([]byte{0}, 1<<20))
+	gw.Close()
+
+	var read int
+	e := New()
+	e.Use(DecompressWithConfig(DecompressConfig{MaxDecompressedSize: 1024}))
+	e.POST("/", func(c *Context) error {
+		b, err := io.ReadAll(c.Request.Body)
+		read = len(b)
+		return err
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/", &bomb)
+	r.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+
+	if w.Code != http.StatusRequestEntityTooLarge || read != 1024 {
+		t.Errorf("got status %d after reading %d bytes", w.Code, read)
+	}
+}
+
+func TestDecompressRestoresTheBody(t *testing.T) {
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte("hello"))
+	gw.Close()
+
+	var r *http.Request
+	var body io.ReadCloser
+	e := New()
+	e.POST("/", func(c *Context) error {
+		r, body = c.Request, c.Request.Body
+		return c.NoContent(http.StatusOK)
+	}, BodyLimit(1024), Decompress())
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(gz.String()))
+	req.Header.Set("Content-Encoding", "gzip")
+	e.ServeHTTP(httptest.NewRecorder(), req)
+
+	if r.Body == body {
+		t.Error("the request still holds the pooled gzip reader")
+	}
+}
@@ -1,47 +1,145 @@
 package pisces
 
 import (
+	"net"
 	"net/http"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/xdatk/pisces/internal/constant"
 	"github.com/xdatk/pisces/internal/util"
 )
 
+const (
+	defaultMultipartMemory = 32 << 20 // 32 MB
+)
+
 // Engine is the framework instance.
 // it contains the muxer, middleware and configuration.
 type Engine struct {
 	RouterGroup
 
-	notFoundHandler HandlerFunc
-	errorHandler    ErrorHandler
+	notFoundHandler         HandlerFunc
+	methodNotAllowedHandler HandlerFunc
+	optionsHandler          HandlerFunc
+	errorHandler            ErrorHandler
+
+	// all* are the fallback handlers wrapped by the engine middlewares.
+	allNotFound         HandlerFunc
+	allMethodNotAllowed HandlerFunc
+	allRedirectFixed    HandlerFunc
+	allOptions          HandlerFunc
 
 	binder    Binder
 	validator Validator
 
+	maxMultipartMemory     int64
+	maxBodySize            int64
+	redirectTrailingSlash  bool
+	handleMethodNotAllowed bool
+	handleOPTIONS          bool
+	trustedProxies         []*net.IPNet
+	ipHeader               string
+
+	serverMu          sync.Mutex
+	server            *http.Server
+	serverDone        chan struct{}
+	serverShutdown    *sync.Once
+	onStart           []StartHook
+	onShutdown        []ShutdownHook
+	shutdownTimeout   time.Duration
+	shutdownSignals   []os.Signal
+	readHeaderTimeout time.Duration
+
 	pool      sync.Pool
 	trees     methodTrees
 	maxParams uint16
 }
 
-func New() *Engine {
+// New returns a new blank Engine instance without any middleware attached, binding with the
+// DefaultBinder, configured by the given options.
+func New(opts ...Option) *Engine {
 	engine := &Engine{
 		RouterGroup: RouterGroup{
 			root:     true,
 			basePath: "/",
 		},
-		notFoundHandler: notFoundHandler,
-		errorHandler:    DefaultErrorHandler,
-		trees:           make(methodTrees, 0, 9),
+		notFoundHandler:         notFoundHandler,
+		methodNotAllowedHandler: methodNotAllowedHandler,
+		optionsHandler:          optionsHandler,
+		errorHandler:            DefaultErrorHandler,
+		binder:                  DefaultBinder(),
+		maxMultipartMemory:      defaultMultipartMemory,
+		redirectTrailingSlash:   true,
+		handleMethodNotAllowed:  true,
+		handleOPTIONS:           true,
+		ipHeader:                constant.HeaderXForwardedFor,
+		shutdownTimeout:         defaultShutdownTimeout,
+		readHeaderTimeout:       defaultReadHeaderTimeout,
+		shutdownSignals:         defaultShutdownSignals(),
+		trees:                   make(methodTrees, 0, 9),
 	}
 	engine.RouterGroup.engine = engine
+	for _, opt := range opts {
+		opt(engine)
+	}
+	engine.compileFallbackHandlers()
 	engine.pool.New = func() interface{} {
 		return engine.allocateContext()
 	}
 	return engine
 }
 
+// Default returns an Engine instance with the Recover middleware already attached,
+// configured by the given options.
+func Default(opts ...Option) *Engine {
+	engine := New(opts...)
+	engine.Use(Recover())
+	return engine
+}
+
+// NoRoute sets the handler called when no route matches the request.
 func (e *Engine) NoRoute(handler HandlerFunc) {
 	e.notFoundHandler = handler
+	e.compileFallbackHandlers()
+}
+
+// MethodNotAllowed sets the handler called when no route matches the request method
+// but another method is allowed for the path, the Allow header is already set.
+func (e *Engine) MethodNotAllowed(handler HandlerFunc) {
+	e.methodNotAllowedHandler = handler
+	e.compileFallbackHandlers()
+}
+
+// Options sets the handler which automatically answers OPTIONS requests without a route,
+// the Allow header is already set.
+func (e *Engine) Options(handler HandlerFunc) {
+	e.optionsHandler = handler
+	e.compileFallbackHandlers()
+}
+
+// compileFallbackHandlers wraps the fallback handlers with the engine middlewares,
+// so they run for every request even when no route matches.
+func (e *Engine) compileFallbackHandlers() {
+	e.allNotFound = applyMiddleware(e.notFoundHandler, e.middlewares...)
+	e.allMethodNotAllowed = applyMiddleware(e.methodNotAllowedHandler, e.middlewares...)
+	e.allRedirectFixed = applyMiddleware(redirectFixedPathHandler, e.middlewares...)
+	e.allOptions = applyMiddleware(e.optionsHandler, e.middlewares...)
+}
+
+// isTrustedProxy reports whether ip belongs to one of the trusted proxy networks.
+func (e *Engine) isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range e.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
 }
 
 func (e *Engine) allocateContext() *Context {
@@ -102,10 +200,7 @@ func (e *Engine) addRouter(method string, path string, handler HandlerFunc, midd
 
 	name := handlerName(handler)
 
-	root.insert(path, name, func(c *Context) error {
-		h := applyMiddleware(handler, middlewares...)
-		return h(c)
-	})
+	root.insert(path, name, applyMiddleware(handler, middlewares...))
 
 	if paramsCount := util.CountParams(path); paramsCount > e.maxParams {
 		e.maxParams = paramsCount
@@ -120,8 +215,16 @@ func (e *Engine) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	c := e.pool.Get().(*Context)
 	c.reset(writer, request)
 
+	// the body wrappers refer to the Context, restore the body before it is reused
+	body := request.Body
+	if e.maxBodySize > 0 {
+		c.limitBody(e.maxBodySize)
+	}
+
 	e.handleHTTPRequest(c)
 
+	request.Body = body
+	c.release()
 	e.pool.Put(c)
 }
 
@@ -152,26 +255,25 @@ func (e *Engine) handleHTTPRequest(c *Context) {
 
 		if value.handler != nil {
 			c.handler = value.handler
-		} else if rMethod != http.MethodConnect && rPath != "/" && value.tsr {
-			c.handler = redirectFixedPathHandler
+		} else if e.redirectTrailingSlash && rMethod != http.MethodConnect && rPath != "/" && value.tsr {
+			c.handler = e.allRedirectFixed
 		}
-	} else {
-		for _, tree := range e.trees {
-			if tree.method == rMethod {
-				continue
-			}
+	}
 
-			tv := tree.root.find(rPath, nil)
+	if c.handler == nil && (e.handleMethodNotAllowed || (e.handleOPTIONS && rMethod == http.MethodOptions)) {
+		if allow := e.allowed(rPath, rMethod); allow != "" {
+			c.Writer.Header().Set(constant.HeaderAllow, allow)
 
-			if tv.handler != nil {
-				c.handler = methodNotAllowedHandler
-				break
+			if e.handleOPTIONS && rMethod == http.MethodOptions {
+				c.handler = e.allOptions
+			} else if e.handleMethodNotAllowed {
+				c.handler = e.allMethodNotAllowed
 			}
 		}
 	}
 
 	if c.handler == nil {
-		c.handler = e.notFoundHandler
+		c.handler = e.allNotFound
 	}
 
 	err := c.handler(c)
@@ -179,3 +281,27 @@ func (e *Engine) handleHTTPRequest(c *Context) {
 		e.errorHandler(c, err)
 	}
 }
+
+// allowed returns the comma separated methods, other than reqMethod, which have a route matching path.
+// The server-wide path "*" matches every registered method.
+func (e *Engine) allowed(path, reqMethod string) string {
+	allow := make([]string, 0, len(e.trees)+1)
+	options := false
+
+	for _, tree := range e.trees {
+		if tree.method == reqMethod {
+			continue
+		}
+
+		if path == "*" || tree.root.find(path, nil).handler != nil {
+			allow = append(allow, tree.method)
+			options = options || tree.method == http.MethodOptions
+		}
+	}
+
+	if len(allow) > 0 && e.handleOPTIONS && !options {
+		allow = append(allow, http.MethodOptions)
+	}
+
+	return strings.Join(allow, ", ")
+}
@@ -0,0 +1,244 @@
+package pisces
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type mockWriter struct {
+	header http.Header
+	status int
+}
+
+func newMockWriter() *mockWriter {
+	return &mockWriter{header: http.Header{}}
+}
+
+func (m *mockWriter) Header() http.Header {
+	return m.header
+}
+
+func (m *mockWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (m *mockWriter) WriteHeader(code int) {
+	m.status = code
+}
+
+func traceMiddleware(trace *[]string, name string) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			*trace = append(*trace, name)
+			return next(c)
+		}
+	}
+}
+
+func performRequest(e *Engine, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestGroupMiddlewareOrder(t *testing.T) {
+	var trace []string
+
+	e := New()
+	e.Use(traceMiddleware(&trace, "engine"))
+
+	api := e.Group("/api", traceMiddleware(&trace, "api"))
+	v1 := api.Group("/v1")
+	api.Use(traceMiddleware(&trace, "api-late"))
+	v1.Use(traceMiddleware(&trace, "v1"))
+
+	v1.GET("/users/:id", func(c *Context) error {
+		trace = append(trace, "handler:"+c.Param("id"))
+		return nil
+	}, traceMiddleware(&trace, "route"))
+
+	performRequest(e, http.MethodGet, "/api/v1/users/42")
+
+	expected := []string{"engine", "api", "api-late", "v1", "route", "handler:42"}
+	if len(trace) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, trace)
+	}
+	for i := range expected {
+		if trace[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, trace)
+		}
+	}
+}
+
+func TestUseAfterRoutePanics(t *testing.T) {
+	noop := func(next HandlerFunc) HandlerFunc { return next }
+
+	e := New()
+	api := e.Group("/api")
+	api.GET("/ping", func(c *Context) error { return nil })
+
+	for name, group := range map[string]*RouterGroup{"engine": &e.RouterGroup, "group": api} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected Use after route registration to panic", name)
+				}
+			}()
+			group.Use(noop)
+		}()
+	}
+
+	// A sibling group without routes can still be configured.
+	e.Group("/admin").Use(noop)
+}
+
+func TestEngineMiddlewareWrapsNotFound(t *testing.T) {
+	var trace []string
+
+	e := New()
+	e.Use(traceMiddleware(&trace, "engine"))
+	e.GET("/ping", func(c *Context) error { return nil })
+
+	performRequest(e, http.MethodGet, "/missing")
+
+	if len(trace) != 1 || trace[0] != "engine" {
+		t.Fatalf("expected engine middleware to run for unmatched routes, got %v", trace)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	noop := func(c *Context) error { return nil }
+
+	e := New()
+	e.GET("/users/:id", noop)
+	e.PUT("/users/:id", noop)
+	e.POST("/users", noop)
+
+	w := performRequest(e, http.MethodDelete, "/users/1")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, PUT, OPTIONS" {
+		t.Fatalf("unexpected Allow header %q", allow)
+	}
+
+	// The method tree exists but the path only matches other methods.
+	w = performRequest(e, http.MethodPost, "/users/1")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+
+	w = performRequest(e, http.MethodDelete, "/missing")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	e = New(WithHandleMethodNotAllowed(false))
+	e.GET("/users/:id", noop)
+
+	w = performRequest(e, http.MethodDelete, "/users/1")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestCustomMethodNotAllowedHandler(t *testing.T) {
+	e := New()
+	e.GET("/ping", func(c *Context) error { return nil })
+	e.MethodNotAllowed(func(c *Context) error {
+		return c.Text(http.StatusMethodNotAllowed, "allowed: "+c.Writer.Header().Get("Allow"))
+	})
+
+	w := performRequest(e, http.MethodPost, "/ping")
+	if w.Code != http.StatusMethodNotAllowed || w.Body.String() != "allowed: GET, OPTIONS" {
+		t.Fatalf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestAutomaticOptions(t *testing.T) {
+	noop := func(c *Context) error { return nil }
+
+	e := New()
+	e.GET("/ping", noop)
+	e.POST("/ping", noop)
+	e.OPTIONS("/custom", func(c *Context) error {
+		return c.Text(http.StatusOK, "custom")
+	})
+	e.GET("/custom", noop)
+
+	w := performRequest(e, http.MethodOptions, "/ping")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, POST, OPTIONS" {
+		t.Fatalf("unexpected Allow header %q", allow)
+	}
+
+	w = performRequest(e, http.MethodOptions, "/custom")
+	if w.Code != http.StatusOK || w.Body.String() != "custom" {
+		t.Fatalf("expected the registered OPTIONS route to win, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = performRequest(e, http.MethodPut, "/custom")
+	if allow := w.Header().Get("Allow"); allow != "GET, OPTIONS" {
+		t.Fatalf("unexpected Allow header %q", allow)
+	}
+
+	e = New(WithHandleOPTIONS(false))
+	e.GET("/ping", noop)
+
+	w = performRequest(e, http.MethodOptions, "/ping")
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET" {
+		t.Fatalf("unexpected response %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+// go test -run=none -bench=^BenchmarkEngine -benchmem
+
+func benchmarkEngine(b *testing.B, path, target string) {
+	passthrough := func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			return next(c)
+		}
+	}
+
+	e := New()
+	e.Use(passthrough)
+	api := e.Group("/api", passthrough)
+	api.GET(path, func(c *Context) error {
+		return nil
+	}, passthrough)
+
+	w := newMockWriter()
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.ServeHTTP(w, r)
+	}
+}
+
+func BenchmarkEngineStaticRoute(b *testing.B) {
+	benchmarkEngine(b, "/users/list", "/api/users/list")
+}
+
+func BenchmarkEngineParamRoute(b *testing.B) {
+	benchmarkEngine(b, "/users/:id/posts/:post", "/api/users/42/posts/7")
+}
+
+func TestEngineHotPathAllocations(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping benchmarks in short mode")
+	}
+
+	for name, bench := range map[string]func(*testing.B){
+		"static": BenchmarkEngineStaticRoute,
+		"param":  BenchmarkEngineParamRoute,
+	} {
+		if allocs := testing.Benchmark(bench).AllocsPerOp(); allocs != 0 {
+			t.Errorf("%s route: expected 0 allocations per request, got %d", name, allocs)
+		}
+	}
+}
@@ -7,10 +7,16 @@ import (
 
 var (
 	ErrNotFound                    = NewHTTPError(http.StatusNotFound, http.StatusText(http.StatusNotFound))
+	ErrMethodNotAllowed            = NewHTTPError(http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+	ErrPreconditionFailed          = NewHTTPError(http.StatusPreconditionFailed, http.StatusText(http.StatusPreconditionFailed))
 	ErrUnsupportedMediaType        = NewHTTPError(http.StatusUnsupportedMediaType, http.StatusText(http.StatusUnsupportedMediaType))
 	ErrStatusRequestEntityTooLarge = NewHTTPError(http.StatusRequestEntityTooLarge, http.StatusText(http.StatusRequestEntityTooLarge))
 	ErrBadRequest                  = NewHTTPError(http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+	ErrUnauthorized                = NewHTTPError(http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+	ErrTooManyRequests             = NewHTTPError(http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests))
 	ErrInternalServerError         = NewHTTPError(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	ErrBadGateway                  = NewHTTPError(http.StatusBadGateway, http.StatusText(http.StatusBadGateway))
+	ErrServiceUnavailable          = NewHTTPError(http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable))
 )
 
 type HTTPError struct {
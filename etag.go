@@ -0,0 +1,178 @@
+package pisces
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xdatk/pisces/internal/constant"
+)
+
+// ETagConfig defines the config for ETag middleware.
+type ETagConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+}
+
+// DefaultETagConfig is the default ETag middleware config.
+var DefaultETagConfig = ETagConfig{
+	Skipper: DefaultSkipper,
+}
+
+// ETag returns a middleware which buffers the responses of GET and HEAD requests, sets
+// a strong ETag hashed from the body of the successful ones, unless the handler set one,
+// and answers the conditional requests with 304 Not Modified or 412 Precondition Failed.
+//
+// Handlers which can tell the validators of the response before producing it should use
+// Context.Conditional instead, to skip the work.
+//
+// While the response is buffered it can neither be flushed nor hijacked, so the requests
+// accepting text/event-stream are not buffered, and streaming or WebSocket routes should
+// be skipped.
+func ETag() MiddlewareFunc {
+	return ETagWithConfig(DefaultETagConfig)
+}
+
+// ETagWithConfig returns an ETag middleware with config, see ETag.
+func ETagWithConfig(config ETagConfig) MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultETagConfig.Skipper
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			method := c.Method()
+			if config.Skipper(c) || method != http.MethodGet && method != http.MethodHead || c.IsWebSocket() ||
+				strings.Contains(c.Header(constant.HeaderAccept), constant.MIMETextEventStream) {
+				return next(c)
+			}
+
+			w := c.Writer
+			bw := newBufferWriter(w.Header())
+			c.Writer = bw
+			defer func() {
+				c.Writer = w
+			}()
+			err := next(c)
+
+			if !bw.wroteHeader {
+				bw.copyHeaderTo(w.Header())
+				return err
+			}
+			if err != nil || bw.status != http.StatusOK {
+				if werr := bw.writeTo(w); err == nil {
+					err = werr
+				}
+				return err
+			}
+
+			header := bw.Header()
+			etag := header.Get(constant.HeaderETag)
+			if etag == "" {
+				sum := sha256.Sum256(bw.body.Bytes())
+				etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+				header.Set(constant.HeaderETag, etag)
+			}
+			lastModified, _ := http.ParseTime(header.Get(constant.HeaderLastModified))
+
+			switch checkPreconditions(c.Request, etag, lastModified) {
+			case http.StatusNotModified:
+				bw.body.Reset()
+				bw.status = http.StatusNotModified
+				header.Del(constant.HeaderContentType)
+				header.Del(constant.HeaderContentLength)
+			case http.StatusPreconditionFailed:
+				return ErrPreconditionFailed
+			}
+			return bw.writeTo(w)
+		}
+	}
+}
+
+// checkPreconditions evaluates the conditional headers of the request against the validators
+// of the selected representation, as RFC 7232 section 6 orders them. It returns
+// http.StatusNotModified, http.StatusPreconditionFailed, or zero if the request can proceed.
+func checkPreconditions(r *http.Request, etag string, lastModified time.Time) int {
+	if ifMatch := r.Header.Get(constant.HeaderIfMatch); ifMatch != "" {
+		if !matchETag(ifMatch, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius, err := http.ParseTime(r.Header.Get(constant.HeaderIfUnmodifiedSince)); err == nil && !lastModified.IsZero() {
+		if lastModified.Truncate(time.Second).After(ius) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+	if ifNoneMatch := r.Header.Get(constant.HeaderIfNoneMatch); ifNoneMatch != "" {
+		if matchETag(ifNoneMatch, etag, true) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get(constant.HeaderIfModifiedSince)); err == nil && safe && !lastModified.IsZero() {
+		if !lastModified.Truncate(time.Second).After(ims) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// matchETag reports whether etag matches one of the entity tags of the If-Match or
+// If-None-Match header value, with the weak comparison if weak is true.
+func matchETag(header, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	for header != "" {
+		header = strings.TrimLeft(header, " \t,")
+		if header == "" {
+			break
+		}
+
+		tag, rest := scanETag(header)
+		if tag == "" {
+			return false
+		}
+		header = rest
+
+		if weak {
+			if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if !strings.HasPrefix(tag, "W/") && tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// scanETag returns the entity tag at the start of s and the rest of s, or an empty tag
+// if s does not start with a valid one.
+func scanETag(s string) (string, string) {
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+	if len(s)-start < 2 || s[start] != '"' {
+		return "", ""
+	}
+	for i := start + 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			return s[:i+1], s[i+1:]
+		case c == 0x21 || c >= 0x23 && c != 0x7f:
+		default:
+			return "", ""
+		}
+	}
+	return "", ""
+}
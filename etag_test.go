@@ -0,0 +1,164 @@
+package pisces
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestETagMiddleware(t *testing.T) {
+	e := New()
+	e.GET("/", func(c *Context) error {
+		return c.Text(http.StatusOK, "hello")
+	}, ETag())
+
+	w := performRequest(e, http.MethodGet, "/")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Body.String() != "hello" {
+		t.Fatalf("got %d %q with ETag %q", w.Code, w.Body.String(), etag)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		code   int
+	}{
+		{"If-None-Match match", "If-None-Match", etag, http.StatusNotModified},
+		{"If-None-Match weak match", "If-None-Match", `"other", W/` + etag, http.StatusNotModified},
+		{"If-None-Match star", "If-None-Match", "*", http.StatusNotModified},
+		{"If-None-Match mismatch", "If-None-Match", `"other"`, http.StatusOK},
+		{"If-Match match", "If-Match", etag, http.StatusOK},
+		{"If-Match weak", "If-Match", "W/" + etag, http.StatusPreconditionFailed},
+		{"If-Match mismatch", "If-Match", `"other"`, http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(tt.header, tt.value)
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+			if w.Code != tt.code {
+				t.Fatalf("got status %d, want %d", w.Code, tt.code)
+			}
+			if w.Code == http.StatusNotModified && (w.Body.Len() != 0 || w.Header().Get("ETag") != etag) {
+				t.Errorf("got 304 with body %q and ETag %q", w.Body.String(), w.Header().Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestContextConditional(t *testing.T) {
+	modified := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	calls := 0
+
+	e := New()
+	e.GET("/", func(c *Context) error {
+		if done, err := c.Conditional(`"v1"`, modified); done {
+			return err
+		}
+		calls++
+		return c.Text(http.StatusOK, "hello")
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		code   int
+	}{
+		{"unconditional", "", "", http.StatusOK},
+		{"If-None-Match", "If-None-Match", `"v1"`, http.StatusNotModified},
+		{"If-Modified-Since not modified", "If-Modified-Since", modified.Format(http.TimeFormat), http.StatusNotModified},
+		{"If-Modified-Since modified", "If-Modified-Since", modified.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK},
+		{"If-Unmodified-Since modified", "If-Unmodified-Since", modified.Add(-time.Hour).Format(http.TimeFormat), http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+			if w.Code != tt.code {
+				t.Fatalf("got status %d, want %d", w.Code, tt.code)
+			}
+			want := 0
+			if tt.code == http.StatusOK {
+				want = 1
+			}
+			if calls != want {
+				t.Errorf("handler ran %d times, want %d", calls, want)
+			}
+		})
+	}
+}
+
+func TestIsCommittedBehindBufferingWriters(t *testing.T) {
+	e := New()
+	e.GET("/", func(c *Context) error {
+		if c.IsCommitted() {
+			t.Error("committed before writing")
+		}
+		if err := c.Text(http.StatusOK, "body"); err != nil {
+			return err
+		}
+		if !c.IsCommitted() {
+			t.Errorf("not committed after writing through %T", c.Writer)
+		}
+		return nil
+	}, ETag(), Compress(), LoggerWithConfig(LoggerConfig{Output: io.Discard}))
+
+	if w := performRequest(e, http.MethodGet, "/"); w.Code != http.StatusOK || w.Body.String() != "body" {
+		t.Errorf("got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestETagDoesNotBufferEventStreams(t *testing.T) {
+	e := New()
+	e.GET("/", func(c *Context) error {
+		c.SetHeader("Content-Type", "text/event-stream")
+		c.Writer.WriteHeader(http.StatusOK)
+		c.Writer.Write([]byte("data: 1\n\n"))
+		c.Writer.Flush()
+		return nil
+	}, ETag())
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+
+	if !w.Flushed || w.Header().Get("ETag") != "" {
+		t.Errorf("got flushed %v and ETag %q", w.Flushed, w.Header().Get("ETag"))
+	}
+}
+
+func TestETagKeepsHeadersOfErrors(t *testing.T) {
+	e := New()
+	e.GET("/", func(c *Context) error {
+		c.SetHeader("Retry-After", "5")
+		return ErrServiceUnavailable
+	}, ETag())
+
+	w := performRequest(e, http.MethodGet, "/")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "5" {
+		t.Errorf("got %d with Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestETagRestoresTheWriterOnPanic(t *testing.T) {
+	e := New()
+	e.Use(RecoverWithConfig(RecoverConfig{Reporter: func(*Context, *PanicError) {}}))
+	e.GET("/", func(c *Context) error {
+		panic("boom")
+	}, ETag())
+
+	if w := performRequest(e, http.MethodGet, "/"); w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d", w.Code)
+	}
+}
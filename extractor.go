@@ -0,0 +1,107 @@
+package pisces
+
+import (
+	"fmt"
+	"strings"
+)
+
+// valueExtractor extracts a credential from the request, it returns an empty string
+// if the request does not carry one.
+type valueExtractor func(*Context) (string, error)
+
+// createExtractors parses a comma separated list of lookups of the form "<source>:<name>",
+// where source is one of header, query, cookie or form. A header lookup may be followed by
+// an authentication scheme, "header:Authorization:Bearer", which is stripped from the value.
+func createExtractors(lookups string) ([]valueExtractor, error) {
+	var extractors []valueExtractor
+	for _, lookup := range strings.Split(lookups, ",") {
+		parts := strings.SplitN(strings.TrimSpace(lookup), ":", 3)
+		if len(parts) < 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid lookup %q", lookup)
+		}
+
+		name := parts[1]
+		switch parts[0] {
+		case "header":
+			scheme := ""
+			if len(parts) == 3 {
+				scheme = strings.TrimSpace(parts[2])
+			}
+			extractors = append(extractors, headerExtractor(name, scheme))
+		case "query":
+			extractors = append(extractors, queryExtractor(name))
+		case "cookie":
+			extractors = append(extractors, cookieExtractor(name))
+		case "form":
+			extractors = append(extractors, formExtractor(name))
+		default:
+			return nil, fmt.Errorf("invalid lookup source %q", parts[0])
+		}
+	}
+	return extractors, nil
+}
+
+// lookupScheme returns the authentication scheme of the first header lookup having one.
+func lookupScheme(lookups string) string {
+	for _, lookup := range strings.Split(lookups, ",") {
+		parts := strings.SplitN(strings.TrimSpace(lookup), ":", 3)
+		if len(parts) == 3 && parts[0] == "header" {
+			return strings.TrimSpace(parts[2])
+		}
+	}
+	return ""
+}
+
+// extractValue returns the first non-empty value of extractors.
+func extractValue(c *Context, extractors []valueExtractor) (string, error) {
+	for _, extract := range extractors {
+		value, err := extract(c)
+		if err != nil {
+			return "", err
+		}
+		if value != "" {
+			return value, nil
+		}
+	}
+	return "", nil
+}
+
+func headerExtractor(name, scheme string) valueExtractor {
+	return func(c *Context) (string, error) {
+		value := c.Header(name)
+		if scheme == "" {
+			return value, nil
+		}
+		if len(value) > len(scheme) && value[len(scheme)] == ' ' && strings.EqualFold(value[:len(scheme)], scheme) {
+			return strings.TrimSpace(value[len(scheme)+1:]), nil
+		}
+		return "", nil
+	}
+}
+
+func queryExtractor(name string) valueExtractor {
+	return func(c *Context) (string, error) {
+		return c.Query(name), nil
+	}
+}
+
+func cookieExtractor(name string) valueExtractor {
+	return func(c *Context) (string, error) {
+		for _, cookie := range c.GetCookies() {
+			if cookie.Name == name {
+				return cookie.Value, nil
+			}
+		}
+		return "", nil
+	}
+}
+
+func formExtractor(name string) valueExtractor {
+	return func(c *Context) (string, error) {
+		value, _, err := c.GetPostForm(name)
+		if err != nil {
+			return "", ErrBadRequest
+		}
+		return value, nil
+	}
+}
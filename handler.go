@@ -1,6 +1,8 @@
 package pisces
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"reflect"
@@ -40,7 +42,11 @@ func redirectFixedPathHandler(c *Context) error {
 }
 
 func methodNotAllowedHandler(c *Context) error {
-	return c.Text(http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+	return ErrMethodNotAllowed
+}
+
+func optionsHandler(c *Context) error {
+	return c.NoContent(http.StatusNoContent)
 }
 
 func handlerName(h HandlerFunc) string {
@@ -57,39 +63,62 @@ func handlerName(h HandlerFunc) string {
 
 type ErrorHandler func(*Context, error)
 
+// DefaultErrorHandler sends the HTTPError, or a 500 for any other error, to the client
+// as JSON if the request has a JSON body, as text otherwise. The request ID, if any,
+// is added to the message and to the logged error.
 func DefaultErrorHandler(c *Context, err error) {
-	if !c.IsCommitted() {
-		he, ok := err.(*HTTPError)
-		if ok {
-			if he.Internal != nil {
-				if herr, ok := he.Internal.(*HTTPError); ok {
-					he = herr
-				}
-			}
-		} else {
-			he = &HTTPError{
-				Code:    http.StatusInternalServerError,
-				Message: err.Error(),
-			}
-		}
-
-		code := he.Code
-		message := he.Message
+	if id := c.RequestID(); id != "" {
+		log.Printf("request_id=%s %v", id, err)
+	} else {
+		log.Printf("%v", err)
+	}
 
-		if c.Method() == http.MethodHead {
-			err = c.NoContent(he.Code)
-		}
+	if c.IsCommitted() {
+		return
+	}
 
-		if c.ContentType() == constant.MIMEApplicationJSON {
-			if m, ok := message.(string); ok {
-				message = map[string]string{"message": m}
+	he := toHTTPError(err)
+	code := he.Code
+	message := he.Message
+
+	switch {
+	case c.Method() == http.MethodHead:
+		err = c.NoContent(code)
+	case c.ContentType() == constant.MIMEApplicationJSON:
+		if m, ok := message.(string); ok {
+			body := map[string]string{"message": m}
+			if id := c.RequestID(); id != "" {
+				body["request_id"] = id
 			}
-			err = c.JSON(code, message)
+			message = body
 		}
+		err = c.JSON(code, message)
+	default:
+		text := fmt.Sprint(message)
+		if id := c.RequestID(); id != "" {
+			text += " (request_id=" + id + ")"
+		}
+		err = c.Text(code, text)
+	}
+
+	if err != nil {
+		log.Printf("%v", err)
+	}
+}
 
-		err = c.Text(code, message.(string))
+// toHTTPError returns the HTTPError DefaultErrorHandler sends for err.
+func toHTTPError(err error) *HTTPError {
+	var he *HTTPError
+	if errors.As(err, &he) {
+		if herr, ok := he.Internal.(*HTTPError); ok {
+			return herr
+		}
+		return he
+	}
+	return &HTTPError{
+		Code:    http.StatusInternalServerError,
+		Message: err.Error(),
 	}
-	log.Printf("%v", err)
 }
 
 /************************************/
@@ -99,6 +128,14 @@ func DefaultErrorHandler(c *Context, err error) {
 // MiddlewareFunc defines a function to process middleware.
 type MiddlewareFunc func(HandlerFunc) HandlerFunc
 
+// Skipper defines a function to skip middleware. Returning true skips processing the middleware.
+type Skipper func(*Context) bool
+
+// DefaultSkipper returns false which processes the middleware.
+func DefaultSkipper(*Context) bool {
+	return false
+}
+
 func applyMiddleware(h HandlerFunc, middleware ...MiddlewareFunc) HandlerFunc {
 	for i := len(middleware) - 1; i >= 0; i-- {
 		h = middleware[i](h)
@@ -0,0 +1,408 @@
+package pisces
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/xdatk/pisces/internal/constant"
+)
+
+const (
+	defaultIdempotencyTTL            = 24 * time.Hour
+	defaultIdempotencyLockTimeout    = time.Minute
+	defaultIdempotencyMaxBodySize    = 1 << 20
+	defaultIdempotencyMaxRequestSize = 1 << 20
+	maxIdempotencyKeyLength          = 255
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned by Idempotency middleware when a key is reused
+	// with another request payload.
+	ErrIdempotencyKeyReused = NewHTTPError(http.StatusUnprocessableEntity, "idempotency key reused with a different payload")
+	// ErrIdempotencyInProgress is returned by Idempotency middleware when the request with
+	// the same key is still running.
+	ErrIdempotencyInProgress = NewHTTPError(http.StatusConflict, "request with the same idempotency key in progress")
+)
+
+// IdempotencyRecord is the state of an idempotency key kept by an IdempotencyStore.
+type IdempotencyRecord struct {
+	// Fingerprint identifies the payload of the request which locked the key.
+	Fingerprint string
+	// Completed reports whether the response is stored, the key is locked otherwise.
+	Completed bool
+	Status    int
+	Header    http.Header
+	Body      []byte
+	// Error is the message of the HTTPError the handlers returned instead of writing
+	// a response, retries get it again.
+	Error string
+}
+
+// IdempotencyStore keeps the records of Idempotency middleware, it must be safe for
+// concurrent use.
+type IdempotencyStore interface {
+	// Lock atomically stores a record for key, which is not completed, for ttl if there
+	// is none and returns true. Otherwise it returns the existing record and false.
+	Lock(ctx context.Context, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, bool, error)
+	// Extend keeps the record of key locked for ttl more, if it is not completed.
+	Extend(ctx context.Context, key string, ttl time.Duration) error
+	// Save stores the completed record of key for ttl.
+	Save(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error
+	// Unlock removes the record of key, so the request can be retried.
+	Unlock(ctx context.Context, key string) error
+}
+
+// IdempotencyConfig defines the config for Idempotency middleware.
+type IdempotencyConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+
+	// Store keeps the records, default a MemoryIdempotencyStore.
+	Store IdempotencyStore
+
+	// Header is the request header carrying the key, default Idempotency-Key.
+	Header string
+
+	// Methods are the request methods the middleware applies to, default POST and PATCH.
+	Methods []string
+
+	// Required rejects the requests without key with ErrBadRequest.
+	Required bool
+
+	// TTL is how long the responses are kept, default 24 hours.
+	TTL time.Duration
+
+	// LockTimeout is how long a key stays locked if its request never completes, for example
+	// when the process crashes, default one minute. The lock is extended every half of it
+	// while the handlers run.
+	LockTimeout time.Duration
+
+	// MaxBodySize is the size above which a response is not stored, the key is then unlocked.
+	// Default 1 MiB.
+	MaxBodySize int
+
+	// MaxRequestSize is the size of the request body above which requests fail with
+	// ErrStatusRequestEntityTooLarge, the body is read to fingerprint the request.
+	// Default 1 MiB.
+	MaxRequestSize int
+
+	// KeyFunc returns who the request is from, it is part of the key so that clients can not
+	// replay the responses of each other, and must not change when a client renews its
+	// credentials. An empty string is shared by every client. Default the principal set by
+	// an authentication middleware, which must then run before, see Context.Principal: the
+	// issuer and the subject of JWTClaims, or the principal formatted with fmt.Sprint.
+	// Requests without principal, or with a JWT without subject, are scoped by their
+	// Context.RealIP.
+	KeyFunc func(*Context) string
+}
+
+// DefaultIdempotencyConfig is the default Idempotency middleware config.
+var DefaultIdempotencyConfig = IdempotencyConfig{
+	Skipper:        DefaultSkipper,
+	Header:         constant.HeaderIdempotencyKey,
+	Methods:        []string{http.MethodPost, http.MethodPatch},
+	TTL:            defaultIdempotencyTTL,
+	LockTimeout:    defaultIdempotencyLockTimeout,
+	MaxBodySize:    defaultIdempotencyMaxBodySize,
+	MaxRequestSize: defaultIdempotencyMaxRequestSize,
+	KeyFunc: func(c *Context) string {
+		switch p := c.Principal().(type) {
+		case nil:
+		case JWTClaims:
+			// the other claims, such as exp and jti, change when the token is renewed
+			if sub := p.Subject(); sub != "" {
+				return "jwt:" + p.Issuer() + " " + sub
+			}
+		default:
+			return "principal:" + fmt.Sprint(p)
+		}
+		return "ip:" + c.RealIP()
+	},
+}
+
+// Idempotency returns a middleware which makes the requests carrying an Idempotency-Key
+// header safe to retry, with an in-memory store.
+//
+// The key is scoped by the principal, or the IP, of the request, see IdempotencyConfig.KeyFunc.
+// It is locked while the first request runs and its response stored, errors included, unless
+// its status is 5xx. Retries with the same key get the stored response, or
+// ErrIdempotencyInProgress while the first request runs, or ErrIdempotencyKeyReused if
+// their method, path or body differ from the ones of the first request.
+func Idempotency() MiddlewareFunc {
+	return IdempotencyWithConfig(DefaultIdempotencyConfig)
+}
+
+// IdempotencyWithConfig returns an Idempotency middleware with config, see Idempotency.
+func IdempotencyWithConfig(config IdempotencyConfig) MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultIdempotencyConfig.Skipper
+	}
+	if config.Store == nil {
+		config.Store = NewMemoryIdempotencyStore()
+	}
+	if config.Header == "" {
+		config.Header = DefaultIdempotencyConfig.Header
+	}
+	if len(config.Methods) == 0 {
+		config.Methods = DefaultIdempotencyConfig.Methods
+	}
+	if config.TTL <= 0 {
+		config.TTL = DefaultIdempotencyConfig.TTL
+	}
+	if config.LockTimeout <= 0 {
+		config.LockTimeout = DefaultIdempotencyConfig.LockTimeout
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = DefaultIdempotencyConfig.MaxBodySize
+	}
+	if config.MaxRequestSize <= 0 {
+		config.MaxRequestSize = DefaultIdempotencyConfig.MaxRequestSize
+	}
+	if config.KeyFunc == nil {
+		config.KeyFunc = DefaultIdempotencyConfig.KeyFunc
+	}
+
+	methods := make(map[string]struct{}, len(config.Methods))
+	for _, m := range config.Methods {
+		methods[m] = struct{}{}
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if _, ok := methods[c.Method()]; !ok || config.Skipper(c) {
+				return next(c)
+			}
+
+			key := c.Header(config.Header)
+			if key == "" {
+				if config.Required {
+					return ErrBadRequest
+				}
+				return next(c)
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				return ErrBadRequest
+			}
+
+			key = config.KeyFunc(c) + "\n" + key
+
+			fingerprint, err := fingerprintRequest(c, config.MaxRequestSize)
+			if err != nil {
+				return err
+			}
+
+			ctx := c.Request.Context()
+			record, locked, err := config.Store.Lock(ctx, key, fingerprint, config.LockTimeout)
+			if err != nil {
+				return err
+			}
+			if !locked {
+				switch {
+				case record.Fingerprint != fingerprint:
+					return ErrIdempotencyKeyReused
+				case !record.Completed:
+					return ErrIdempotencyInProgress
+				}
+				return replayIdempotent(c, record)
+			}
+
+			stop := extendIdempotencyLock(config.Store, key, config.LockTimeout)
+			completed := false
+			defer func() {
+				stop()
+				if !completed {
+					// the handlers panicked, or the response can not be stored
+					_ = config.Store.Unlock(context.Background(), key)
+				}
+			}()
+
+			w := c.Writer
+			outer := w.Header().Clone()
+			rw := &cacheWriter{ResponseWriter: w, status: http.StatusOK, max: config.MaxBodySize}
+			c.Writer = rw
+			defer func() {
+				c.Writer = w
+			}()
+			err = next(c)
+
+			record = &IdempotencyRecord{Fingerprint: fingerprint, Completed: true}
+			switch {
+			case rw.wroteHeader:
+				if rw.overflow || rw.status >= http.StatusInternalServerError {
+					return err
+				}
+				record.Status = rw.status
+				record.Header = rw.header
+				record.Body = rw.body
+			case err != nil:
+				// the error is rendered by the error handler, retries get it from the record
+				he := toHTTPError(err)
+				message, ok := he.Message.(string)
+				if !ok || he.Code >= http.StatusInternalServerError {
+					return err
+				}
+				record.Status = he.Code
+				record.Header = w.Header().Clone()
+				record.Error = message
+			default:
+				return nil
+			}
+
+			stop()
+			removeOuterHeader(record.Header, outer)
+			if serr := config.Store.Save(context.Background(), key, record, config.TTL); serr != nil && err == nil {
+				return serr
+			}
+			completed = true
+			return err
+		}
+	}
+}
+
+// extendIdempotencyLock extends the lock of key every half of ttl until the returned func
+// is called, the func waits for the last extension to return.
+func extendIdempotencyLock(store IdempotencyStore, key string, ttl time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(ttl / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = store.Extend(context.Background(), key, ttl)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+func replayIdempotent(c *Context, record *IdempotencyRecord) error {
+	header := c.Writer.Header()
+	for k, v := range record.Header {
+		header[k] = append([]string(nil), v...)
+	}
+	if record.Error != "" {
+		return NewHTTPError(record.Status, record.Error)
+	}
+	c.Writer.WriteHeader(record.Status)
+	if len(record.Body) == 0 {
+		return nil
+	}
+	_, err := c.Writer.Write(record.Body)
+	return err
+}
+
+// fingerprintRequest hashes the method, the path and the body of the request, which must
+// not exceed limit, the body is then read again from memory.
+func fingerprintRequest(c *Context, limit int) (string, error) {
+	if c.Request.ContentLength > int64(limit) {
+		return "", ErrStatusRequestEntityTooLarge
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, int64(limit)+1))
+	if err != nil {
+		return "", err
+	}
+	if len(body) > limit {
+		return "", ErrStatusRequestEntityTooLarge
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	h := sha256.New()
+	h.Write([]byte(c.Method()))
+	h.Write([]byte{0})
+	h.Write([]byte(c.Path()))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	records   map[string]*memoryIdempotencyEntry
+	lastSweep time.Time
+}
+
+type memoryIdempotencyEntry struct {
+	record    *IdempotencyRecord
+	expiresAt time.Time
+}
+
+// NewMemoryIdempotencyStore returns an empty MemoryIdempotencyStore.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		records: make(map[string]*memoryIdempotencyEntry),
+	}
+}
+
+// Lock implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Lock(_ context.Context, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.records {
+			if now.After(e.expiresAt) {
+				delete(s.records, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if e, ok := s.records[key]; ok && now.Before(e.expiresAt) {
+		return e.record, false, nil
+	}
+	s.records[key] = &memoryIdempotencyEntry{
+		record:    &IdempotencyRecord{Fingerprint: fingerprint},
+		expiresAt: now.Add(ttl),
+	}
+	return nil, true, nil
+}
+
+// Extend implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Extend(_ context.Context, key string, ttl time.Duration) error {
+	now := time.Now()
+
+	s.mu.Lock()
+	if e, ok := s.records[key]; ok && !e.record.Completed && now.Before(e.expiresAt) {
+		e.expiresAt = now.Add(ttl)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Save implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Save(_ context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	s.records[key] = &memoryIdempotencyEntry{record: record, expiresAt: time.Now().Add(ttl)}
+	s.mu.Unlock()
+	return nil
+}
+
+// Unlock implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Unlock(_ context.Context, key string) error {
+	s.mu.Lock()
+	delete(s.records, key)
+	s.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,228 @@
+package pisces
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func performIdempotent(e *Engine, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set("Idempotency-Key", key)
+	}
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdempotency(t *testing.T) {
+	var calls int32
+	e := New()
+	e.Use(Idempotency())
+	e.POST("/", func(c *Context) error {
+		n := atomic.AddInt32(&calls, 1)
+		c.SetHeader("X-Call", strconv.Itoa(int(n)))
+		return c.Data(http.StatusCreated, "text/plain", []byte("created"))
+	})
+
+	w := performIdempotent(e, "k1", "payload")
+	if w.Code != http.StatusCreated || w.Body.String() != "created" {
+		t.Fatalf("got %d %q", w.Code, w.Body.String())
+	}
+
+	w = performIdempotent(e, "k1", "payload")
+	if w.Code != http.StatusCreated || w.Body.String() != "created" || w.Header().Get("X-Call") != "1" || calls != 1 {
+		t.Errorf("got %d %q from call %q after %d calls, want the stored response", w.Code, w.Body.String(), w.Header().Get("X-Call"), calls)
+	}
+
+	if w = performIdempotent(e, "k1", "other payload"); w.Code != http.StatusUnprocessableEntity || calls != 1 {
+		t.Errorf("got %d after %d calls for a reused key", w.Code, calls)
+	}
+
+	performIdempotent(e, "", "payload")
+	performIdempotent(e, "", "payload")
+	if calls != 3 {
+		t.Errorf("got %d calls, the requests without key must run", calls)
+	}
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	release := make(chan struct{})
+	e := New()
+	e.Use(IdempotencyWithConfig(IdempotencyConfig{LockTimeout: 40 * time.Millisecond}))
+	e.POST("/", func(c *Context) error {
+		<-release
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	done := make(chan int)
+	go func() {
+		done <- performIdempotent(e, "k1", "payload").Code
+	}()
+
+	// the lock outlives LockTimeout while the first request runs
+	time.Sleep(120 * time.Millisecond)
+	if w := performIdempotent(e, "k1", "payload"); w.Code != http.StatusConflict {
+		t.Errorf("got %d while the first request runs", w.Code)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusNoContent {
+		t.Errorf("got %d for the first request", code)
+	}
+	if w := performIdempotent(e, "k1", "payload"); w.Code != http.StatusNoContent {
+		t.Errorf("got %d once the first request completed", w.Code)
+	}
+}
+
+func TestIdempotencyErrors(t *testing.T) {
+	var calls int32
+	var errs []error
+	e := New(WithErrorHandler(func(c *Context, err error) {
+		errs = append(errs, err)
+		DefaultErrorHandler(c, err)
+	}))
+	e.Use(Recover(), Idempotency())
+	e.POST("/", func(c *Context) error {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			return c.Data(http.StatusServiceUnavailable, "text/plain", []byte("later"))
+		case 2:
+			panic("boom")
+		}
+		return NewHTTPError(http.StatusNotFound, "no such thing")
+	})
+
+	if w := performIdempotent(e, "k1", "payload"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d", w.Code)
+	}
+	if w := performIdempotent(e, "k1", "payload"); w.Code != http.StatusInternalServerError || calls != 2 {
+		t.Fatalf("got %d after %d calls, 5xx responses must not be stored", w.Code, calls)
+	}
+
+	w := performIdempotent(e, "k1", "payload")
+	if w.Code != http.StatusNotFound || calls != 3 {
+		t.Fatalf("got %d after %d calls, the key must be unlocked after a panic", w.Code, calls)
+	}
+	if len(errs) != 2 || errs[1].(*HTTPError).Message != "no such thing" {
+		t.Fatalf("got errors %v, want the one returned by the handler", errs)
+	}
+
+	w = performIdempotent(e, "k1", "payload")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "no such thing") || calls != 3 {
+		t.Errorf("got %d %q after %d calls, want the stored error", w.Code, w.Body.String(), calls)
+	}
+}
+
+func TestIdempotencyScopesKeysByPrincipal(t *testing.T) {
+	var calls int32
+	e := New()
+	e.Use(func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			c.SetPrincipal(c.Header("X-User"))
+			return next(c)
+		}
+	}, Idempotency())
+	e.POST("/", func(c *Context) error {
+		atomic.AddInt32(&calls, 1)
+		return c.Data(http.StatusOK, "text/plain", []byte(c.Principal().(string)))
+	})
+
+	for _, user := range []string{"alice", "bob", "alice"} {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("payload"))
+		r.Header.Set("Idempotency-Key", "k1")
+		r.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		if w.Body.String() != user {
+			t.Errorf("%s got the response of %q", user, w.Body.String())
+		}
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls, want one per principal", calls)
+	}
+}
+
+func TestIdempotencyScopesJWTBySubject(t *testing.T) {
+	secret := []byte("secret")
+	var calls int32
+	e := New()
+	e.Use(JWT(secret), Idempotency())
+	e.POST("/", func(c *Context) error {
+		atomic.AddInt32(&calls, 1)
+		return c.NoContent(http.StatusCreated)
+	})
+
+	now := time.Now().Unix()
+	for i, claims := range []map[string]interface{}{
+		{"sub": "alice", "iat": now, "exp": now + 60, "jti": "1"},
+		// the token is renewed before the retry
+		{"sub": "alice", "iat": now + 30, "exp": now + 90, "jti": "2"},
+		{"sub": "bob", "iat": now, "exp": now + 60, "jti": "3"},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("payload"))
+		r.Header.Set("Idempotency-Key", "k1")
+		r.Header.Set("Authorization", "Bearer "+signJWT(t, "HS256", "", secret, claims))
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("request %d: got %d", i, w.Code)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls, want one per subject", calls)
+	}
+}
+
+func TestIdempotencyScopesAnonymousRequestsByIP(t *testing.T) {
+	var calls int32
+	e := New()
+	e.Use(Idempotency())
+	e.POST("/", func(c *Context) error {
+		atomic.AddInt32(&calls, 1)
+		return c.Data(http.StatusOK, "text/plain", []byte(c.RealIP()))
+	})
+
+	for _, addr := range []string{"203.0.113.1:1234", "203.0.113.2:1234", "203.0.113.1:4321"} {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("payload"))
+		r.RemoteAddr = addr
+		r.Header.Set("Idempotency-Key", "k1")
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		if ip := addr[:strings.Index(addr, ":")]; w.Body.String() != ip {
+			t.Errorf("%s got the response of %q", ip, w.Body.String())
+		}
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls, want one per client", calls)
+	}
+}
+
+func TestIdempotencyCapsRequestBody(t *testing.T) {
+	e := New()
+	e.Use(IdempotencyWithConfig(IdempotencyConfig{MaxRequestSize: 8}))
+	e.POST("/", func(c *Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	if w := performIdempotent(e, "k1", "12345678"); w.Code != http.StatusNoContent {
+		t.Errorf("got %d for a body within the limit", w.Code)
+	}
+	if w := performIdempotent(e, "k2", "123456789"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got %d for a body over the limit", w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("123456789"))
+	r.ContentLength = -1
+	r.Header.Set("Idempotency-Key", "k3")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got %d for a body of unknown length over the limit", w.Code)
+	}
+}
@@ -3,22 +3,32 @@ package constant
 // Headers
 const (
 	HeaderAccept              = "Accept"
+	HeaderAge                 = "Age"
 	HeaderAcceptEncoding      = "Accept-Encoding"
 	HeaderAllow               = "Allow"
 	HeaderAuthorization       = "Authorization"
+	HeaderCacheControl        = "Cache-Control"
 	HeaderContentDisposition  = "Content-Disposition"
 	HeaderContentEncoding     = "Content-Encoding"
 	HeaderContentLength       = "Content-Length"
+	HeaderContentRange        = "Content-Range"
 	HeaderContentType         = "Content-Type"
 	HeaderCookie              = "Cookie"
+	HeaderETag                = "ETag"
+	HeaderForwarded           = "Forwarded"
 	HeaderSetCookie           = "Set-Cookie"
+	HeaderIdempotencyKey      = "Idempotency-Key"
+	HeaderIfMatch             = "If-Match"
 	HeaderIfModifiedSince     = "If-Modified-Since"
+	HeaderIfNoneMatch         = "If-None-Match"
+	HeaderIfUnmodifiedSince   = "If-Unmodified-Since"
 	HeaderLastModified        = "Last-Modified"
 	HeaderLocation            = "Location"
 	HeaderUpgrade             = "Upgrade"
 	HeaderVary                = "Vary"
 	HeaderWWWAuthenticate     = "WWW-Authenticate"
 	HeaderXForwardedFor       = "X-Forwarded-For"
+	HeaderXForwardedHost      = "X-Forwarded-Host"
 	HeaderXForwardedProto     = "X-Forwarded-Proto"
 	HeaderXForwardedPrefix    = "X-Forwarded-Prefix"
 	HeaderXForwardedProtocol  = "X-Forwarded-Protocol"
@@ -30,6 +40,11 @@ const (
 	HeaderXRequestedWith      = "X-Requested-With"
 	HeaderServer              = "Server"
 	HeaderOrigin              = "Origin"
+	HeaderRetryAfter          = "Retry-After"
+
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
 
 	HeaderAccessControlRequestMethod    = "Access-Control-Request-Method"
 	HeaderAccessControlRequestHeaders   = "Access-Control-Request-Headers"
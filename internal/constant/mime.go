@@ -12,4 +12,5 @@ const (
 	MIMETextPlainCharsetUTF8       = MIMETextPlain + "; " + charsetUTF8
 	MIMEMultipartForm              = "multipart/form-data"
 	MIMEOctetStream                = "application/octet-stream"
+	MIMETextEventStream            = "text/event-stream"
 )
@@ -0,0 +1,112 @@
+package util
+
+import (
+	"net"
+	"strings"
+)
+
+// ForwardedElement is a forwarded-element of the RFC 7239 Forwarded header.
+type ForwardedElement struct {
+	For   string
+	By    string
+	Host  string
+	Proto string
+}
+
+// ParseForwarded parses the values of the Forwarded header, in order. Node identifiers of
+// the "for" and "by" parameters are returned without quotes, brackets and ports.
+func ParseForwarded(values []string) []ForwardedElement {
+	var elements []ForwardedElement
+
+	for _, value := range values {
+		for _, element := range splitQuoted(value, ',') {
+			var e ForwardedElement
+
+			for _, pair := range splitQuoted(element, ';') {
+				i := strings.IndexByte(pair, '=')
+				if i < 0 {
+					continue
+				}
+
+				key := strings.ToLower(strings.TrimSpace(pair[:i]))
+				val := unquote(strings.TrimSpace(pair[i+1:]))
+
+				switch key {
+				case "for":
+					e.For = forwardedNode(val)
+				case "by":
+					e.By = forwardedNode(val)
+				case "host":
+					e.Host = val
+				case "proto":
+					e.Proto = strings.ToLower(val)
+				}
+			}
+
+			elements = append(elements, e)
+		}
+	}
+
+	return elements
+}
+
+// splitQuoted splits s around sep outside of quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			if quoted {
+				i++
+			}
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+
+	s = s[1 : len(s)-1]
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func forwardedNode(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if i := strings.IndexByte(node, ']'); i > 0 {
+			return node[1:i]
+		}
+		return node
+	}
+
+	if strings.Count(node, ":") == 1 {
+		if host, _, err := net.SplitHostPort(node); err == nil {
+			return host
+		}
+	}
+	return node
+}
@@ -0,0 +1,21 @@
+package util
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/xdatk/pisces/internal/constant"
+)
+
+// AddVary adds value to the Vary header unless it is already listed.
+func AddVary(header http.Header, value string) {
+	for _, line := range header.Values(constant.HeaderVary) {
+		for _, v := range strings.Split(line, ",") {
+			v = strings.TrimSpace(v)
+			if v == "*" || strings.EqualFold(v, value) {
+				return
+			}
+		}
+	}
+	header.Add(constant.HeaderVary, value)
+}
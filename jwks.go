@@ -0,0 +1,272 @@
+package pisces
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJWKSRefreshInterval    = time.Hour
+	defaultJWKSMinRefreshInterval = 5 * time.Minute
+	defaultJWKSTimeout            = 10 * time.Second
+	defaultJWKSRetryBackoff       = time.Second
+
+	maxJWKSSize = 1 << 20
+)
+
+// JWKSConfig defines the config for JWKS.
+type JWKSConfig struct {
+	// URL is the location of the JWKS document, either URL or File is required.
+	URL string
+
+	// File is the path of a local JWKS document.
+	File string
+
+	// Client is the HTTP client fetching URL, default http.DefaultClient.
+	Client *http.Client
+
+	// Timeout is the maximum duration of a load, default 10 seconds. Loads do not use the
+	// context of the request needing the keys, so a canceled request does not fail them.
+	Timeout time.Duration
+
+	// RefreshInterval is how long the keys are cached, default one hour.
+	RefreshInterval time.Duration
+
+	// MinRefreshInterval is the minimum time between two loads, default five minutes.
+	// The document is loaded again when a token has an unknown key ID, at most once per interval.
+	MinRefreshInterval time.Duration
+}
+
+// JWKS is a JWTKeySet loading its keys from a JSON Web Key Set document.
+// The keys are loaded on first use and cached, the last loaded keys are kept when loading fails.
+// Failed loads are retried with an exponential backoff, from one second up to MinRefreshInterval.
+type JWKS struct {
+	config JWKSConfig
+
+	// loadMu serializes the loads.
+	loadMu sync.Mutex
+
+	mu        sync.RWMutex
+	keys      map[string]jwk
+	loadedAt  time.Time
+	attemptAt time.Time
+	retryAt   time.Time
+	failures  int
+	err       error
+
+	// now is replaced by tests.
+	now func() time.Time
+}
+
+type jwk struct {
+	alg string
+	key interface{}
+}
+
+// NewJWKS returns a JWKS configured by config.
+func NewJWKS(config JWKSConfig) *JWKS {
+	if config.URL == "" && config.File == "" {
+		panic("jwks url or file is required")
+	}
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultJWKSTimeout
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = defaultJWKSRefreshInterval
+	}
+	if config.MinRefreshInterval <= 0 {
+		config.MinRefreshInterval = defaultJWKSMinRefreshInterval
+	}
+	return &JWKS{config: config, now: time.Now}
+}
+
+// Key implements JWTKeySet.
+func (s *JWKS) Key(_ context.Context, kid, alg string) (interface{}, error) {
+	s.mu.RLock()
+	k, found := s.keys[kid]
+	loadedAt, attemptAt, retryAt := s.loadedAt, s.attemptAt, s.retryAt
+	s.mu.RUnlock()
+
+	now := s.now()
+	expired := now.Sub(loadedAt) > s.config.RefreshInterval
+	if (expired || !found && now.Sub(attemptAt) > s.config.MinRefreshInterval) && !now.Before(retryAt) {
+		s.load(attemptAt)
+	}
+
+	s.mu.RLock()
+	k, found = s.keys[kid]
+	keys, err := s.keys, s.err
+	s.mu.RUnlock()
+
+	if !found {
+		if keys == nil && err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w %q", errJWTUnknownKey, kid)
+	}
+	if k.alg != "" && k.alg != alg {
+		return nil, errJWTKeyType
+	}
+	return k.key, nil
+}
+
+// load loads the document, unless another goroutine attempted to since attemptAt.
+// On failure the previous keys are kept and the next attempt is delayed.
+func (s *JWKS) load(attemptAt time.Time) {
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+
+	s.mu.RLock()
+	attempted := !s.attemptAt.Equal(attemptAt)
+	s.mu.RUnlock()
+	if attempted {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	defer cancel()
+	keys, err := s.fetch(ctx)
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attemptAt = now
+	if err != nil {
+		backoff := defaultJWKSRetryBackoff
+		for i := 0; i < s.failures && backoff < s.config.MinRefreshInterval; i++ {
+			backoff *= 2
+		}
+		if backoff > s.config.MinRefreshInterval {
+			backoff = s.config.MinRefreshInterval
+		}
+		s.failures++
+		s.retryAt = now.Add(backoff)
+		s.err = err
+		return
+	}
+	s.keys = keys
+	s.loadedAt = now
+	s.failures = 0
+	s.retryAt = time.Time{}
+	s.err = nil
+}
+
+func (s *JWKS) fetch(ctx context.Context) (map[string]jwk, error) {
+	var r io.Reader
+	if s.config.File != "" {
+		f, err := os.Open(s.config.File)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	} else {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.config.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("jwks: unexpected status %d", resp.StatusCode)
+		}
+		r = resp.Body
+	}
+
+	var doc struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r, maxJWKSSize)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+
+	keys := make(map[string]jwk, len(doc.Keys))
+	for _, raw := range doc.Keys {
+		k, kid, err := parseJWK(raw)
+		if err != nil {
+			// unsupported keys are skipped, the document may contain keys for other uses
+			continue
+		}
+		keys[kid] = k
+	}
+	return keys, nil
+}
+
+var errJWKUnsupported = errors.New("jwks: unsupported key")
+
+func parseJWK(raw json.RawMessage) (jwk, string, error) {
+	var v struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+		K   string `json:"k"`
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return jwk{}, "", err
+	}
+	if v.Use != "" && v.Use != "sig" {
+		return jwk{}, "", errJWKUnsupported
+	}
+
+	k := jwk{alg: v.Alg}
+	switch v.Kty {
+	case "RSA":
+		n, err1 := decodeJWKInt(v.N)
+		e, err2 := decodeJWKInt(v.E)
+		if err1 != nil || err2 != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return jwk{}, "", errJWKUnsupported
+		}
+		k.key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		if v.Crv != "P-256" {
+			return jwk{}, "", errJWKUnsupported
+		}
+		x, err1 := decodeJWKInt(v.X)
+		y, err2 := decodeJWKInt(v.Y)
+		if err1 != nil || err2 != nil || !elliptic.P256().IsOnCurve(x, y) {
+			return jwk{}, "", errJWKUnsupported
+		}
+		k.key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(v.K)
+		if err != nil || len(secret) == 0 {
+			return jwk{}, "", errJWKUnsupported
+		}
+		k.key = secret
+	default:
+		return jwk{}, "", errJWKUnsupported
+	}
+	return k, v.Kid, nil
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errJWKUnsupported
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,409 @@
+package pisces
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xdatk/pisces/internal/constant"
+)
+
+// JWT signing algorithms supported by JWT middleware.
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmHS384 = "HS384"
+	JWTAlgorithmHS512 = "HS512"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmES256 = "ES256"
+)
+
+var (
+	errJWTMalformed        = errors.New("jwt: malformed token")
+	errJWTAlgorithm        = errors.New("jwt: unexpected signing algorithm")
+	errJWTUnknownKey       = errors.New("jwt: unknown signing key")
+	errJWTKeyType          = errors.New("jwt: signing key does not match the algorithm")
+	errJWTSignature        = errors.New("jwt: invalid signature")
+	errJWTExpired          = errors.New("jwt: token is expired")
+	errJWTMissingExp       = errors.New("jwt: token has no expiration time")
+	errJWTNotValidYet      = errors.New("jwt: token is not valid yet")
+	errJWTInvalidIssuer    = errors.New("jwt: invalid issuer")
+	errJWTInvalidAudience  = errors.New("jwt: invalid audience")
+	errJWTInvalidTimeClaim = errors.New("jwt: invalid time claim")
+)
+
+// JWTClaims are the claims of a verified token.
+type JWTClaims map[string]interface{}
+
+// Subject returns the "sub" claim.
+func (claims JWTClaims) Subject() string {
+	s, _ := claims["sub"].(string)
+	return s
+}
+
+// Issuer returns the "iss" claim.
+func (claims JWTClaims) Issuer() string {
+	s, _ := claims["iss"].(string)
+	return s
+}
+
+// Audience returns the "aud" claim, which is either a string or an array of strings.
+func (claims JWTClaims) Audience() []string {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		audience := make([]string, 0, len(aud))
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audience = append(audience, s)
+			}
+		}
+		return audience
+	}
+	return nil
+}
+
+// ExpiresAt returns the "exp" claim, or the zero time if it is absent.
+func (claims JWTClaims) ExpiresAt() time.Time {
+	t, _, _ := claims.time("exp")
+	return t
+}
+
+// NotBefore returns the "nbf" claim, or the zero time if it is absent.
+func (claims JWTClaims) NotBefore() time.Time {
+	t, _, _ := claims.time("nbf")
+	return t
+}
+
+// IssuedAt returns the "iat" claim, or the zero time if it is absent.
+func (claims JWTClaims) IssuedAt() time.Time {
+	t, _, _ := claims.time("iat")
+	return t
+}
+
+func (claims JWTClaims) time(name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, true, errJWTInvalidTimeClaim
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, true, errJWTInvalidTimeClaim
+	}
+	sec, frac := int64(f), f-float64(int64(f))
+	return time.Unix(sec, int64(frac*1e9)), true, nil
+}
+
+// JWTKeySet provides the keys tokens are verified with.
+type JWTKeySet interface {
+	// Key returns the key of the given key ID for the given algorithm: a []byte for
+	// HS256/384/512, an *rsa.PublicKey for RS256 or an *ecdsa.PublicKey for ES256.
+	// The key ID is empty if the token header has none.
+	Key(ctx context.Context, kid, alg string) (interface{}, error)
+}
+
+// JWTConfig defines the config for JWT middleware.
+type JWTConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+
+	// TokenLookup is a comma separated list of "<source>:<name>" the token is looked up from,
+	// in order, default "header:Authorization:Bearer", see KeyAuthConfig.KeyLookup.
+	TokenLookup string
+
+	// SigningKey is the key used to verify tokens, see JWTKeySet.Key for its type.
+	// It is used for tokens without a key ID or whose key ID is not in SigningKeys.
+	SigningKey interface{}
+
+	// SigningKeys are the keys used to verify tokens, by key ID.
+	SigningKeys map[string]interface{}
+
+	// KeySet provides the keys used to verify tokens, such as a JWKS.
+	// SigningKey and SigningKeys are ignored if it is set.
+	KeySet JWTKeySet
+
+	// Algorithms are the accepted signing algorithms, default all the supported ones.
+	Algorithms []string
+
+	// Issuer is the expected "iss" claim, it is not checked if empty.
+	Issuer string
+
+	// Audience are the accepted "aud" claims, the token must have one of them.
+	// It is not checked if empty.
+	Audience []string
+
+	// ClockSkew is the tolerance when checking the "exp" and "nbf" claims.
+	ClockSkew time.Duration
+
+	// AllowMissingExp accepts tokens without an "exp" claim, which never expire.
+	// By default they are rejected.
+	AllowMissingExp bool
+
+	// Realm is the protection space sent in the WWW-Authenticate challenge, default "Restricted".
+	Realm string
+}
+
+// DefaultJWTConfig is the default JWT middleware config.
+var DefaultJWTConfig = JWTConfig{
+	Skipper:     DefaultSkipper,
+	TokenLookup: "header:Authorization:Bearer",
+	Algorithms: []string{
+		JWTAlgorithmHS256,
+		JWTAlgorithmHS384,
+		JWTAlgorithmHS512,
+		JWTAlgorithmRS256,
+		JWTAlgorithmES256,
+	},
+	Realm: defaultRealm,
+}
+
+// JWT returns a middleware which authenticates requests with a JSON Web Token of the
+// Authorization header, verified with key. The JWTClaims of the token are stored on the
+// Context, see Context.Principal, requests failing authentication get a WWW-Authenticate
+// challenge and a 401 error.
+func JWT(key interface{}) MiddlewareFunc {
+	config := DefaultJWTConfig
+	config.SigningKey = key
+	return JWTWithConfig(config)
+}
+
+// JWTWithConfig returns a JWT middleware with config, see JWT.
+func JWTWithConfig(config JWTConfig) MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultJWTConfig.Skipper
+	}
+	if config.TokenLookup == "" {
+		config.TokenLookup = DefaultJWTConfig.TokenLookup
+	}
+	if len(config.Algorithms) == 0 {
+		config.Algorithms = DefaultJWTConfig.Algorithms
+	}
+	if config.Realm == "" {
+		config.Realm = DefaultJWTConfig.Realm
+	}
+	if config.KeySet == nil {
+		if config.SigningKey == nil && len(config.SigningKeys) == 0 {
+			panic("jwt signing key must not be nil")
+		}
+		config.KeySet = staticJWTKeySet{key: config.SigningKey, keys: config.SigningKeys}
+	}
+
+	extractors, err := createExtractors(config.TokenLookup)
+	if err != nil {
+		panic(err)
+	}
+
+	algorithms := make(map[string]struct{}, len(config.Algorithms))
+	for _, alg := range config.Algorithms {
+		algorithms[alg] = struct{}{}
+	}
+
+	challenge := "Bearer realm=" + strconv.Quote(config.Realm)
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			token, err := extractValue(c, extractors)
+			if err != nil {
+				return err
+			}
+			if token == "" {
+				c.SetHeader(constant.HeaderWWWAuthenticate, challenge)
+				return ErrUnauthorized
+			}
+
+			claims, err := parseJWT(c.Request.Context(), token, &config, algorithms)
+			if err != nil {
+				c.SetHeader(constant.HeaderWWWAuthenticate, challenge+`, error="invalid_token"`)
+				return NewHTTPError(http.StatusUnauthorized).SetInternal(err)
+			}
+
+			c.principal = claims
+			return next(c)
+		}
+	}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// parseJWT verifies a compact JWS token and validates its claims.
+func parseJWT(ctx context.Context, token string, config *JWTConfig, algorithms map[string]struct{}) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errJWTMalformed
+	}
+
+	var header jwtHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if _, ok := algorithms[header.Alg]; !ok {
+		return nil, errJWTAlgorithm
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errJWTMalformed
+	}
+
+	key, err := config.KeySet.Key(ctx, header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, token[:len(parts[0])+1+len(parts[1])], signature); err != nil {
+		return nil, err
+	}
+
+	var claims JWTClaims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := validateJWTClaims(claims, config, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errJWTMalformed
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(v); err != nil {
+		return errJWTMalformed
+	}
+	return nil
+}
+
+func verifyJWTSignature(alg string, key interface{}, signed string, signature []byte) error {
+	switch alg {
+	case JWTAlgorithmHS256, JWTAlgorithmHS384, JWTAlgorithmHS512:
+		secret, ok := key.([]byte)
+		if !ok || len(secret) == 0 {
+			return errJWTKeyType
+		}
+		newHash := sha256.New
+		if alg == JWTAlgorithmHS384 {
+			newHash = sha512.New384
+		} else if alg == JWTAlgorithmHS512 {
+			newHash = sha512.New
+		}
+		mac := hmac.New(newHash, secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return errJWTSignature
+		}
+		return nil
+	case JWTAlgorithmRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errJWTKeyType
+		}
+		digest := sha256.Sum256([]byte(signed))
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+			return errJWTSignature
+		}
+		return nil
+	case JWTAlgorithmES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() {
+			return errJWTKeyType
+		}
+		if len(signature) != 64 {
+			return errJWTSignature
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		digest := sha256.Sum256([]byte(signed))
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return errJWTSignature
+		}
+		return nil
+	default:
+		return errJWTAlgorithm
+	}
+}
+
+func validateJWTClaims(claims JWTClaims, config *JWTConfig, now time.Time) error {
+	exp, ok, err := claims.time("exp")
+	if err != nil {
+		return err
+	}
+	if !ok && !config.AllowMissingExp {
+		return errJWTMissingExp
+	}
+	if ok && !now.Before(exp.Add(config.ClockSkew)) {
+		return errJWTExpired
+	}
+
+	nbf, ok, err := claims.time("nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(config.ClockSkew).Before(nbf) {
+		return errJWTNotValidYet
+	}
+
+	if config.Issuer != "" && claims.Issuer() != config.Issuer {
+		return errJWTInvalidIssuer
+	}
+
+	if len(config.Audience) > 0 && !matchAudience(claims.Audience(), config.Audience) {
+		return errJWTInvalidAudience
+	}
+	return nil
+}
+
+func matchAudience(audience, accepted []string) bool {
+	for _, a := range audience {
+		for _, b := range accepted {
+			if a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// staticJWTKeySet is the JWTKeySet of JWTConfig.SigningKey and JWTConfig.SigningKeys.
+type staticJWTKeySet struct {
+	key  interface{}
+	keys map[string]interface{}
+}
+
+func (s staticJWTKeySet) Key(_ context.Context, kid, _ string) (interface{}, error) {
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	if s.key != nil {
+		return s.key, nil
+	}
+	return nil, fmt.Errorf("%w %q", errJWTUnknownKey, kid)
+}
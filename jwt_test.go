@@ -0,0 +1,303 @@
+package pisces
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, _ := json.Marshal(header)
+	p, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func jwtEngine(config JWTConfig) *Engine {
+	e := New()
+	e.GET("/", func(c *Context) error {
+		return c.Text(http.StatusOK, c.Principal().(JWTClaims).Subject())
+	}, JWTWithConfig(config))
+	return e
+}
+
+func performJWTRequest(e *Engine, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	return w
+}
+
+func TestJWTAlgorithms(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := jwtEngine(JWTConfig{SigningKeys: map[string]interface{}{
+		"hs": secret,
+		"rs": &rsaKey.PublicKey,
+		"es": &ecKey.PublicKey,
+	}})
+	claims := map[string]interface{}{"sub": "joe", "exp": time.Now().Add(time.Hour).Unix()}
+
+	tests := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"HS256", signJWT(t, JWTAlgorithmHS256, "hs", secret, claims), http.StatusOK},
+		{"RS256", signJWT(t, JWTAlgorithmRS256, "rs", rsaKey, claims), http.StatusOK},
+		{"ES256", signJWT(t, JWTAlgorithmES256, "es", ecKey, claims), http.StatusOK},
+		{"wrong secret", signJWT(t, JWTAlgorithmHS256, "hs", []byte("other"), claims), http.StatusUnauthorized},
+		{"algorithm confusion", signJWT(t, JWTAlgorithmHS256, "rs", secret, claims), http.StatusUnauthorized},
+		{"unknown kid", signJWT(t, JWTAlgorithmHS256, "xx", secret, claims), http.StatusUnauthorized},
+		{"none", "eyJhbGciOiJub25lIn0.eyJzdWIiOiJqb2UifQ.", http.StatusUnauthorized},
+		{"malformed", "abc", http.StatusUnauthorized},
+		{"missing", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := performJWTRequest(e, tt.token)
+			if w.Code != tt.code {
+				t.Fatalf("got status %d, want %d", w.Code, tt.code)
+			}
+			if w.Code == http.StatusOK && w.Body.String() != "joe" {
+				t.Errorf("got subject %q, want %q", w.Body.String(), "joe")
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("missing WWW-Authenticate challenge")
+			}
+		})
+	}
+}
+
+func TestJWTClaimsValidation(t *testing.T) {
+	secret := []byte("secret")
+	e := jwtEngine(JWTConfig{
+		SigningKey: secret,
+		Issuer:     "https://issuer.example.com",
+		Audience:   []string{"api"},
+		ClockSkew:  time.Minute,
+	})
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		code   int
+	}{
+		{"valid", map[string]interface{}{"iss": "https://issuer.example.com", "aud": []string{"web", "api"}, "exp": now + 60}, http.StatusOK},
+		{"expired within skew", map[string]interface{}{"iss": "https://issuer.example.com", "aud": "api", "exp": now - 30}, http.StatusOK},
+		{"expired", map[string]interface{}{"iss": "https://issuer.example.com", "aud": "api", "exp": now - 120}, http.StatusUnauthorized},
+		{"not valid yet within skew", map[string]interface{}{"iss": "https://issuer.example.com", "aud": "api", "exp": now + 60, "nbf": now + 30}, http.StatusOK},
+		{"not valid yet", map[string]interface{}{"iss": "https://issuer.example.com", "aud": "api", "exp": now + 60, "nbf": now + 120}, http.StatusUnauthorized},
+		{"invalid exp", map[string]interface{}{"iss": "https://issuer.example.com", "aud": "api", "exp": "tomorrow"}, http.StatusUnauthorized},
+		{"missing exp", map[string]interface{}{"iss": "https://issuer.example.com", "aud": "api"}, http.StatusUnauthorized},
+		{"wrong issuer", map[string]interface{}{"iss": "https://other.example.com", "aud": "api", "exp": now + 60}, http.StatusUnauthorized},
+		{"wrong audience", map[string]interface{}{"iss": "https://issuer.example.com", "aud": "web", "exp": now + 60}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.claims["sub"] = "joe"
+			w := performJWTRequest(e, signJWT(t, JWTAlgorithmHS256, "", secret, tt.claims))
+			if w.Code != tt.code {
+				t.Fatalf("got status %d, want %d", w.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestJWKSRefreshesOnUnknownKey(t *testing.T) {
+	key1, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key2, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	jwkOf := func(kid string, k *ecdsa.PrivateKey) map[string]string {
+		return map[string]string{
+			"kty": "EC",
+			"crv": "P-256",
+			"kid": kid,
+			"x":   base64.RawURLEncoding.EncodeToString(padJWKInt(k.X)),
+			"y":   base64.RawURLEncoding.EncodeToString(padJWKInt(k.Y)),
+		}
+	}
+
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := []map[string]string{jwkOf("k1", key1)}
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			keys = append(keys, jwkOf("k2", key2))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer srv.Close()
+
+	jwks := NewJWKS(JWKSConfig{URL: srv.URL, MinRefreshInterval: time.Nanosecond})
+	e := jwtEngine(JWTConfig{KeySet: jwks, AllowMissingExp: true})
+	claims := map[string]interface{}{"sub": "joe"}
+
+	for i := 0; i < 2; i++ {
+		if w := performJWTRequest(e, signJWT(t, JWTAlgorithmES256, "k1", key1, claims)); w.Code != http.StatusOK {
+			t.Fatalf("k1: got status %d, want %d", w.Code, http.StatusOK)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("got %d fetches, want the keys to be cached", n)
+	}
+
+	if w := performJWTRequest(e, signJWT(t, JWTAlgorithmES256, "k2", key2, claims)); w.Code != http.StatusOK {
+		t.Fatalf("k2: got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Fatalf("got %d fetches, want a refresh for the unknown key", n)
+	}
+}
+
+func TestJWTAllowMissingExp(t *testing.T) {
+	secret := []byte("secret")
+	token := signJWT(t, JWTAlgorithmHS256, "", secret, map[string]interface{}{"sub": "joe"})
+
+	if w := performJWTRequest(jwtEngine(JWTConfig{SigningKey: secret}), token); w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d for a token without exp by default", w.Code)
+	}
+	if w := performJWTRequest(jwtEngine(JWTConfig{SigningKey: secret, AllowMissingExp: true}), token); w.Code != http.StatusOK {
+		t.Errorf("got status %d for a token without exp when allowed", w.Code)
+	}
+}
+
+func TestJWKSRetriesFailedLoadsWithBackoff(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	doc, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "EC",
+		"crv": "P-256",
+		"kid": "k1",
+		"x":   base64.RawURLEncoding.EncodeToString(padJWKInt(key.X)),
+		"y":   base64.RawURLEncoding.EncodeToString(padJWKInt(key.Y)),
+	}}})
+
+	var fetches, failing int32 = 0, 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(doc)
+	}))
+	defer srv.Close()
+
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	jwks := NewJWKS(JWKSConfig{URL: srv.URL, RefreshInterval: time.Hour, MinRefreshInterval: time.Minute})
+	jwks.now = clock.now
+
+	key1 := func() error {
+		_, err := jwks.Key(context.Background(), "k1", JWTAlgorithmES256)
+		return err
+	}
+	expect := func(n int32, ok bool) {
+		t.Helper()
+		if err := key1(); (err == nil) != ok {
+			t.Fatalf("got error %v", err)
+		}
+		if got := atomic.LoadInt32(&fetches); got != n {
+			t.Fatalf("got %d fetches, want %d", got, n)
+		}
+	}
+
+	// the first load fails and is retried after 1s, then 2s
+	expect(1, false)
+	expect(1, false)
+	clock.advance(time.Second)
+	expect(2, false)
+	clock.advance(time.Second)
+	expect(2, false)
+	clock.advance(time.Second)
+	expect(3, false)
+
+	atomic.StoreInt32(&failing, 0)
+	clock.advance(4 * time.Second)
+	expect(4, true)
+
+	// once expired, a failing refresh keeps the previous keys and does not count as loaded
+	atomic.StoreInt32(&failing, 1)
+	clock.advance(time.Hour + time.Second)
+	expect(5, true)
+	expect(5, true)
+	clock.advance(time.Second)
+	expect(6, true)
+
+	atomic.StoreInt32(&failing, 0)
+	clock.advance(2 * time.Second)
+	expect(7, true)
+	clock.advance(time.Minute)
+	expect(7, true)
+}
+
+func TestJWKSLoadsDetachedFromTheRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"keys":[{"kty":"oct","kid":"k1","k":"c2VjcmV0"}]}`))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	jwks := NewJWKS(JWKSConfig{URL: srv.URL})
+	if _, err := jwks.Key(ctx, "k1", JWTAlgorithmHS256); err != nil {
+		t.Fatalf("got error %v with a canceled request", err)
+	}
+}
+
+func padJWKInt(n *big.Int) []byte {
+	b := make([]byte, 32)
+	return n.FillBytes(b)
+}
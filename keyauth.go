@@ -0,0 +1,117 @@
+package pisces
+
+import (
+	"strconv"
+
+	"github.com/xdatk/pisces/internal/constant"
+)
+
+// KeyAuthValidator validates the key of a request and returns the authenticated principal.
+// A non-nil error is returned by the middleware as is.
+type KeyAuthValidator func(c *Context, key string) (principal interface{}, ok bool, err error)
+
+// KeyAuthConfig defines the config for KeyAuth middleware.
+type KeyAuthConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+
+	// KeyLookup is a comma separated list of "<source>:<name>" the key is looked up from,
+	// in order, default "header:X-API-Key". Source is one of:
+	// - "header:<name>" or "header:<name>:<scheme>", for example "header:Authorization:Bearer"
+	// - "query:<name>"
+	// - "cookie:<name>"
+	// - "form:<name>"
+	KeyLookup string
+
+	// Validator validates the key, required.
+	Validator KeyAuthValidator
+
+	// Realm is the protection space sent in the WWW-Authenticate challenge, default "Restricted".
+	Realm string
+}
+
+// DefaultKeyAuthConfig is the default KeyAuth middleware config.
+var DefaultKeyAuthConfig = KeyAuthConfig{
+	Skipper:   DefaultSkipper,
+	KeyLookup: "header:X-API-Key",
+	Realm:     defaultRealm,
+}
+
+// KeyAuth returns a middleware which authenticates requests with the API key of the
+// X-API-Key header. The principal returned by the validator is stored on the Context,
+// see Context.Principal, requests failing authentication get a WWW-Authenticate challenge
+// and ErrUnauthorized.
+func KeyAuth(validator KeyAuthValidator) MiddlewareFunc {
+	config := DefaultKeyAuthConfig
+	config.Validator = validator
+	return KeyAuthWithConfig(config)
+}
+
+// KeyAuthWithConfig returns a KeyAuth middleware with config, see KeyAuth.
+func KeyAuthWithConfig(config KeyAuthConfig) MiddlewareFunc {
+	if config.Validator == nil {
+		panic("key auth validator must not be nil")
+	}
+	if config.Skipper == nil {
+		config.Skipper = DefaultKeyAuthConfig.Skipper
+	}
+	if config.KeyLookup == "" {
+		config.KeyLookup = DefaultKeyAuthConfig.KeyLookup
+	}
+	if config.Realm == "" {
+		config.Realm = DefaultKeyAuthConfig.Realm
+	}
+
+	extractors, err := createExtractors(config.KeyLookup)
+	if err != nil {
+		panic(err)
+	}
+
+	scheme := lookupScheme(config.KeyLookup)
+	if scheme == "" {
+		scheme = "ApiKey"
+	}
+	challenge := scheme + " realm=" + strconv.Quote(config.Realm)
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			key, err := extractValue(c, extractors)
+			if err != nil {
+				return err
+			}
+
+			if key != "" {
+				principal, valid, err := config.Validator(c, key)
+				if err != nil {
+					return err
+				}
+				if valid {
+					c.principal = principal
+					return next(c)
+				}
+			}
+
+			c.SetHeader(constant.HeaderWWWAuthenticate, challenge)
+			return ErrUnauthorized
+		}
+	}
+}
+
+// KeyAuthKeys returns a KeyAuthValidator accepting the keys of the given map, compared
+// in constant time. The principal is the value the key maps to.
+func KeyAuthKeys(keys map[string]interface{}) KeyAuthValidator {
+	return func(_ *Context, key string) (interface{}, bool, error) {
+		var principal interface{}
+		found := false
+		for k, p := range keys {
+			if secureCompare(key, k) && !found {
+				principal, found = p, true
+			}
+		}
+		return principal, found, nil
+	}
+}
@@ -0,0 +1,106 @@
+package pisces
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestKeyAuth(t *testing.T) {
+	e := New()
+	e.Use(KeyAuth(KeyAuthKeys(map[string]interface{}{"key-1": "service-1", "key-2": "service-2"})))
+	e.GET("/", func(c *Context) error {
+		return c.Text(http.StatusOK, c.Principal().(string))
+	})
+
+	tests := []struct {
+		key  string
+		code int
+		body string
+	}{
+		{"key-1", http.StatusOK, "service-1"},
+		{"key-2", http.StatusOK, "service-2"},
+		{"key-3", http.StatusUnauthorized, ""},
+		{"key-", http.StatusUnauthorized, ""},
+		{"", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.key != "" {
+			r.Header.Set("X-API-Key", tt.key)
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+
+		if w.Code != tt.code || tt.code == http.StatusOK && w.Body.String() != tt.body {
+			t.Errorf("%q: got %d %q, want %d %q", tt.key, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+		if tt.code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != `ApiKey realm="Restricted"` {
+			t.Errorf("%q: got challenge %q", tt.key, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestKeyAuthLookups(t *testing.T) {
+	e := New()
+	e.Use(KeyAuthWithConfig(KeyAuthConfig{
+		KeyLookup: "header:Authorization:Bearer, query:api_key, cookie:key, form:key",
+		Validator: func(c *Context, key string) (interface{}, bool, error) {
+			return key, key == "valid", nil
+		},
+	}))
+	e.POST("/", func(c *Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	form := url.Values{"key": {"valid"}}.Encode()
+	tests := []struct {
+		name   string
+		target string
+		header map[string]string
+		body   string
+		code   int
+	}{
+		{name: "bearer", header: map[string]string{"Authorization": "Bearer valid"}, code: http.StatusOK},
+		{name: "bearer case insensitive", header: map[string]string{"Authorization": "bearer valid"}, code: http.StatusOK},
+		{name: "other scheme", header: map[string]string{"Authorization": "Basic valid"}, code: http.StatusUnauthorized},
+		{name: "scheme without separator", header: map[string]string{"Authorization": "Bearervalid"}, code: http.StatusUnauthorized},
+		{name: "query", target: "/?api_key=valid", code: http.StatusOK},
+		{name: "cookie", header: map[string]string{"Cookie": "key=valid"}, code: http.StatusOK},
+		{name: "form", header: map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, body: form, code: http.StatusOK},
+		{name: "first lookup wins", target: "/?api_key=valid", header: map[string]string{"Authorization": "Bearer invalid"}, code: http.StatusUnauthorized},
+		{name: "falls back past empty lookups", target: "/?api_key=", header: map[string]string{"Authorization": "Bearer ", "Cookie": "key=valid"}, code: http.StatusOK},
+		{name: "none", code: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			if target == "" {
+				target = "/"
+			}
+			r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(tt.body))
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Errorf("got status %d, want %d", w.Code, tt.code)
+			}
+			if tt.code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != `Bearer realm="Restricted"` {
+				t.Errorf("got challenge %q", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestCreateExtractorsRejectsInvalidLookups(t *testing.T) {
+	for _, lookup := range []string{"", "header", "header:", "body:key", "query:a,param:b"} {
+		if _, err := createExtractors(lookup); err == nil {
+			t.Errorf("%q: expected an error", lookup)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package pisces
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LogFormat is the output format of Logger middleware.
+type LogFormat int
+
+const (
+	// LogFormatCombined is the Apache combined log format,
+	// followed by the request ID and the latency in microseconds.
+	LogFormatCombined LogFormat = iota
+	// LogFormatLogfmt writes key=value pairs.
+	LogFormatLogfmt
+	// LogFormatJSON writes one JSON object per line.
+	LogFormatJSON
+)
+
+const (
+	combinedTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+// LoggerConfig defines the config for Logger middleware.
+type LoggerConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+
+	// Format is the output format, default LogFormatCombined.
+	Format LogFormat
+
+	// Output is the writer the entries are written to, default os.Stdout.
+	Output io.Writer
+
+	// SkipPaths are request paths or route templates which are not logged.
+	SkipPaths []string
+
+	// SkipStatus reports whether a response with the given status is not logged.
+	SkipStatus func(status int) bool
+}
+
+// DefaultLoggerConfig is the default Logger middleware config.
+var DefaultLoggerConfig = LoggerConfig{
+	Skipper: DefaultSkipper,
+	Format:  LogFormatCombined,
+	Output:  os.Stdout,
+}
+
+// LogEntry is a single access log entry.
+type LogEntry struct {
+	Time      time.Time     `json:"time"`
+	RequestID string        `json:"request_id,omitempty"`
+	RemoteIP  string        `json:"remote_ip"`
+	Method    string        `json:"method"`
+	URI       string        `json:"uri"`
+	Path      string        `json:"path"`
+	Route     string        `json:"route,omitempty"`
+	Handler   string        `json:"handler,omitempty"`
+	Protocol  string        `json:"protocol"`
+	Status    int           `json:"status"`
+	Bytes     int           `json:"bytes"`
+	Latency   time.Duration `json:"latency"`
+	Referer   string        `json:"referer,omitempty"`
+	UserAgent string        `json:"user_agent,omitempty"`
+}
+
+// Logger returns a middleware which writes an access log entry for every request.
+func Logger() MiddlewareFunc {
+	return LoggerWithConfig(DefaultLoggerConfig)
+}
+
+// LoggerWithConfig returns a Logger middleware with config, see Logger.
+//
+// Errors returned by the next handlers are returned as is, the logged status of a response
+// they did not write is the one DefaultErrorHandler sends for the error. The status and the
+// size are the ones the next handlers write, middlewares added before the Logger, such as
+// ETag, may still change them.
+func LoggerWithConfig(config LoggerConfig) MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultLoggerConfig.Skipper
+	}
+	if config.Output == nil {
+		config.Output = DefaultLoggerConfig.Output
+	}
+
+	skipPaths := make(map[string]struct{}, len(config.SkipPaths))
+	for _, p := range config.SkipPaths {
+		skipPaths[p] = struct{}{}
+	}
+
+	var mu sync.Mutex
+	pool := sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			start := time.Now()
+
+			rec := newStatusRecorder(c.Writer)
+			c.Writer = rec
+			err := next(c)
+			c.Writer = rec.ResponseWriter
+			status, size := rec.result(err)
+
+			if _, ok := skipPaths[c.Path()]; ok {
+				return err
+			}
+			if _, ok := skipPaths[c.fullPath]; ok && c.fullPath != "" {
+				return err
+			}
+			if config.SkipStatus != nil && config.SkipStatus(status) {
+				return err
+			}
+
+			entry := LogEntry{
+				Time:      start,
+				RequestID: c.RequestID(),
+				RemoteIP:  c.RealIP(),
+				Method:    c.Method(),
+				URI:       c.Request.RequestURI,
+				Path:      c.Path(),
+				Route:     c.fullPath,
+				Handler:   c.handlerName,
+				Protocol:  c.Request.Proto,
+				Status:    status,
+				Bytes:     size,
+				Latency:   time.Since(start),
+				Referer:   c.Request.Referer(),
+				UserAgent: c.UserAgent(),
+			}
+			if entry.URI == "" {
+				entry.URI = c.Request.URL.RequestURI()
+			}
+
+			buf := pool.Get().(*bytes.Buffer)
+			buf.Reset()
+			defer pool.Put(buf)
+
+			switch config.Format {
+			case LogFormatJSON:
+				if jerr := json.NewEncoder(buf).Encode(entry); jerr != nil {
+					log.Printf("logger: %v", jerr)
+					return err
+				}
+			case LogFormatLogfmt:
+				writeLogfmt(buf, &entry)
+			default:
+				writeCombined(buf, &entry)
+			}
+
+			mu.Lock()
+			_, werr := config.Output.Write(buf.Bytes())
+			mu.Unlock()
+			if werr != nil {
+				log.Printf("logger: %v", werr)
+			}
+			return err
+		}
+	}
+}
+
+func writeCombined(buf *bytes.Buffer, e *LogEntry) {
+	buf.WriteString(e.RemoteIP)
+	buf.WriteString(" - - [")
+	buf.WriteString(e.Time.Format(combinedTimeFormat))
+	buf.WriteString("] \"")
+	buf.WriteString(e.Method)
+	buf.WriteByte(' ')
+	buf.WriteString(e.URI)
+	buf.WriteByte(' ')
+	buf.WriteString(e.Protocol)
+	buf.WriteString("\" ")
+	buf.WriteString(strconv.Itoa(e.Status))
+	buf.WriteByte(' ')
+	if e.Bytes > 0 {
+		buf.WriteString(strconv.Itoa(e.Bytes))
+	} else {
+		buf.WriteByte('-')
+	}
+	buf.WriteByte(' ')
+	writeCombinedQuoted(buf, e.Referer)
+	buf.WriteByte(' ')
+	writeCombinedQuoted(buf, e.UserAgent)
+	buf.WriteByte(' ')
+	writeCombinedQuoted(buf, e.RequestID)
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatInt(e.Latency.Microseconds(), 10))
+	buf.WriteByte('\n')
+}
+
+func writeCombinedQuoted(buf *bytes.Buffer, s string) {
+	if s == "" {
+		buf.WriteString(`"-"`)
+		return
+	}
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			buf.WriteString(`\x`)
+			buf.WriteByte("0123456789abcdef"[c>>4])
+			buf.WriteByte("0123456789abcdef"[c&0xf])
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('"')
+}
+
+func writeLogfmt(buf *bytes.Buffer, e *LogEntry) {
+	writeLogfmtPair(buf, "time", e.Time.Format(time.RFC3339Nano))
+	writeLogfmtPair(buf, "request_id", e.RequestID)
+	writeLogfmtPair(buf, "remote_ip", e.RemoteIP)
+	writeLogfmtPair(buf, "method", e.Method)
+	writeLogfmtPair(buf, "uri", e.URI)
+	writeLogfmtPair(buf, "path", e.Path)
+	writeLogfmtPair(buf, "route", e.Route)
+	writeLogfmtPair(buf, "handler", e.Handler)
+	writeLogfmtPair(buf, "protocol", e.Protocol)
+	writeLogfmtPair(buf, "status", strconv.Itoa(e.Status))
+	writeLogfmtPair(buf, "bytes", strconv.Itoa(e.Bytes))
+	writeLogfmtPair(buf, "latency", e.Latency.String())
+	writeLogfmtPair(buf, "referer", e.Referer)
+	writeLogfmtPair(buf, "user_agent", e.UserAgent)
+	buf.Truncate(buf.Len() - 1)
+	buf.WriteByte('\n')
+}
+
+func writeLogfmtPair(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key)
+	buf.WriteByte('=')
+
+	quote := value == ""
+	for i := 0; i < len(value) && !quote; i++ {
+		c := value[i]
+		quote = c <= ' ' || c == '=' || c == '"' || c == 0x7f
+	}
+
+	if quote {
+		buf.WriteString(strconv.Quote(value))
+	} else {
+		buf.WriteString(value)
+	}
+	buf.WriteByte(' ')
+}
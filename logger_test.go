@@ -0,0 +1,128 @@
+package pisces
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLoggerFormats(t *testing.T) {
+	tests := []struct {
+		format LogFormat
+		want   *regexp.Regexp
+	}{
+		{LogFormatCombined, regexp.MustCompile(`^192\.0\.2\.1 - - \[[^]]+\] "GET /users/42\?q=1 HTTP/1\.1" 200 5 "https://example\.com/" "test \\"agent\\"" "-" \d+\n$`)},
+		{LogFormatLogfmt, regexp.MustCompile(`^time=\S+ request_id="" remote_ip=192\.0\.2\.1 method=GET uri="/users/42\?q=1" path=/users/42 route=/users/:id handler=\S+ protocol=HTTP/1\.1 status=200 bytes=5 latency=\S+ referer=https://example\.com/ user_agent="test \\"agent\\""\n$`)},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		e := New()
+		e.Use(LoggerWithConfig(LoggerConfig{Format: tt.format, Output: &buf}))
+		e.GET("/users/:id", func(c *Context) error {
+			return c.Text(http.StatusOK, "hello")
+		})
+
+		r := httptest.NewRequest(http.MethodGet, "/users/42?q=1", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("Referer", "https://example.com/")
+		r.Header.Set("User-Agent", `test "agent"`)
+		e.ServeHTTP(httptest.NewRecorder(), r)
+
+		if !tt.want.MatchString(buf.String()) {
+			t.Errorf("format %d: got %q", tt.format, buf.String())
+		}
+	}
+}
+
+func TestLoggerReturnsErrors(t *testing.T) {
+	var buf bytes.Buffer
+	var handled error
+	errBoom := errors.New("boom")
+
+	e := New(WithErrorHandler(func(c *Context, err error) {
+		handled = err
+		DefaultErrorHandler(c, err)
+	}))
+	e.Use(LoggerWithConfig(LoggerConfig{Format: LogFormatJSON, Output: &buf}))
+	e.GET("/missing", func(c *Context) error {
+		return ErrNotFound
+	})
+	e.GET("/boom", func(c *Context) error {
+		return errBoom
+	})
+
+	tests := []struct {
+		path string
+		err  error
+		code int
+	}{
+		{"/missing", ErrNotFound, http.StatusNotFound},
+		{"/boom", errBoom, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		handled = nil
+		w := performRequest(e, http.MethodGet, tt.path)
+
+		if handled != tt.err || w.Code != tt.code {
+			t.Errorf("%s: got %d and error %v, want %d and %v", tt.path, w.Code, handled, tt.code, tt.err)
+		}
+		var entry LogEntry
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Status != tt.code || entry.Path != tt.path {
+			t.Errorf("%s: got entry %+v", tt.path, entry)
+		}
+	}
+}
+
+func TestLoggerStatusBehindBufferingWriter(t *testing.T) {
+	var buf bytes.Buffer
+	e := New()
+	e.GET("/", func(c *Context) error {
+		return c.Text(http.StatusTeapot, "tea")
+	}, ETag(), LoggerWithConfig(LoggerConfig{Format: LogFormatLogfmt, Output: &buf}))
+
+	if w := performRequest(e, http.MethodGet, "/"); w.Code != http.StatusTeapot {
+		t.Fatalf("got status %d", w.Code)
+	}
+	if !strings.Contains(buf.String(), " status=418 bytes=3 ") {
+		t.Errorf("got %q", buf.String())
+	}
+}
+
+func TestLoggerSkips(t *testing.T) {
+	var buf bytes.Buffer
+	e := New()
+	e.Use(LoggerWithConfig(LoggerConfig{
+		Output:    &buf,
+		SkipPaths: []string{"/health", "/static/*filepath"},
+		SkipStatus: func(status int) bool {
+			return status == http.StatusNotFound
+		},
+	}))
+	ok := func(c *Context) error {
+		return c.NoContent(http.StatusOK)
+	}
+	e.GET("/health", ok)
+	e.GET("/static/*filepath", ok)
+	e.GET("/api", ok)
+
+	for _, path := range []string{"/health", "/static/app.js", "/missing"} {
+		performRequest(e, http.MethodGet, path)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("got %q", buf.String())
+	}
+
+	performRequest(e, http.MethodGet, "/api")
+	if !strings.Contains(buf.String(), `"GET /api HTTP/1.1" 200 -`) {
+		t.Errorf("got %q", buf.String())
+	}
+}
@@ -0,0 +1,151 @@
+package pisces
+
+import (
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/xdatk/pisces/internal/constant"
+)
+
+// Option configures an Engine, see New.
+type Option func(*Engine)
+
+// WithBinder sets the Binder used by Context.Bind and the other Bind methods, default DefaultBinder.
+func WithBinder(binder Binder) Option {
+	return func(e *Engine) {
+		e.binder = binder
+	}
+}
+
+// WithValidator sets the Validator used by Context.Validate, there is none by default.
+func WithValidator(validator Validator) Option {
+	return func(e *Engine) {
+		e.validator = validator
+	}
+}
+
+// WithErrorHandler sets the handler called with the errors returned by handlers.
+func WithErrorHandler(handler ErrorHandler) Option {
+	return func(e *Engine) {
+		e.errorHandler = handler
+	}
+}
+
+// WithMaxMultipartMemory sets the maxMemory argument passed to http.Request.ParseMultipartForm.
+func WithMaxMultipartMemory(maxMemory int64) Option {
+	return func(e *Engine) {
+		e.maxMultipartMemory = maxMemory
+	}
+}
+
+// WithMaxBodySize caps the body of every request to maxSize bytes, reads past it return
+// ErrStatusRequestEntityTooLarge. Zero, the default, means no limit. See BodyLimit for
+// per route limits.
+func WithMaxBodySize(maxSize int64) Option {
+	return func(e *Engine) {
+		e.maxBodySize = maxSize
+	}
+}
+
+// WithRedirectTrailingSlash enables automatic redirection if the current route can't be matched
+// but a handler for the path with (without) the trailing slash exists.
+func WithRedirectTrailingSlash(enable bool) Option {
+	return func(e *Engine) {
+		e.redirectTrailingSlash = enable
+	}
+}
+
+// WithHandleMethodNotAllowed enables checking if another method is allowed for the current route,
+// if the current request can not be routed.
+func WithHandleMethodNotAllowed(enable bool) Option {
+	return func(e *Engine) {
+		e.handleMethodNotAllowed = enable
+	}
+}
+
+// WithTrustedProxies sets the networks, in CIDR notation or as single IP addresses, of the proxies
+// whose forwarded header, see WithIPHeader, is trusted by Context.RealIP and Context.Scheme.
+// It panics if a proxy can not be parsed. By default no proxy is trusted.
+func WithTrustedProxies(proxies ...string) Option {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				panic("invalid trusted proxy '" + proxy + "'")
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			panic("invalid trusted proxy '" + proxy + "': " + err.Error())
+		}
+		networks = append(networks, network)
+	}
+
+	return func(e *Engine) {
+		e.trustedProxies = networks
+	}
+}
+
+// WithIPHeader sets the header the trusted proxies forward the client address in, one of
+// Forwarded, X-Forwarded-For and X-Real-IP, default X-Forwarded-For. It must be the header
+// the proxies set or append to, since a client can send the other ones. The scheme is read
+// from the Forwarded header with Forwarded, from X-Forwarded-Proto otherwise.
+// It panics on another header.
+func WithIPHeader(header string) Option {
+	ipHeader := ""
+	for _, h := range []string{constant.HeaderForwarded, constant.HeaderXForwardedFor, constant.HeaderXRealIP} {
+		if strings.EqualFold(header, h) {
+			ipHeader = h
+		}
+	}
+	if ipHeader == "" {
+		panic("invalid IP header '" + header + "'")
+	}
+
+	return func(e *Engine) {
+		e.ipHeader = ipHeader
+	}
+}
+
+// WithHandleOPTIONS enables automatic replies to OPTIONS requests without a route,
+// based on the methods registered for the path.
+func WithHandleOPTIONS(enable bool) Option {
+	return func(e *Engine) {
+		e.handleOPTIONS = enable
+	}
+}
+
+// WithShutdownTimeout sets how long Run waits for in-flight requests to finish
+// after receiving one of the shutdown signals.
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(e *Engine) {
+		e.shutdownTimeout = timeout
+	}
+}
+
+// WithShutdownSignals sets the signals that make Run shut the server down gracefully,
+// no signals disables the signal handling. The default is os.Interrupt and syscall.SIGTERM.
+func WithShutdownSignals(signals ...os.Signal) Option {
+	return func(e *Engine) {
+		e.shutdownSignals = signals
+	}
+}
+
+// WithReadHeaderTimeout sets the http.Server ReadHeaderTimeout of Run and the other Run methods,
+// how long the server waits for the headers of a request. The default is 10 seconds,
+// zero means no timeout.
+func WithReadHeaderTimeout(timeout time.Duration) Option {
+	return func(e *Engine) {
+		e.readHeaderTimeout = timeout
+	}
+}
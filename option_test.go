@@ -0,0 +1,162 @@
+package pisces
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bindTarget struct {
+	Name  string `form:"name" header:"X-Name" json:"name"`
+	Count int    `form:"count" header:"X-Count" json:"count"`
+}
+
+func TestNewBindsWithDefaultBinder(t *testing.T) {
+	bind := func(c *Context, v *bindTarget) error {
+		return c.Bind(v)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		header  map[string]string
+		body    string
+		bind    func(*Context, *bindTarget) error
+		wantErr bool
+	}{
+		{"Bind GET", http.MethodGet, "/?name=a&count=2", nil, "", bind, false},
+		{"Bind JSON", http.MethodPost, "/", map[string]string{"Content-Type": "application/json; charset=utf-8"},
+			`{"name":"a","count":2}`, bind, false},
+		{"Bind form", http.MethodPost, "/", map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+			"name=a&count=2", bind, false},
+		{"Bind unsupported", http.MethodPost, "/", map[string]string{"Content-Type": "text/plain"},
+			"a", bind, true},
+		{"BindQuery", http.MethodPost, "/?name=a&count=2", nil, "", func(c *Context, v *bindTarget) error {
+			return c.BindQuery(v)
+		}, false},
+		{"BindHeader", http.MethodGet, "/", map[string]string{"X-Name": "a", "X-Count": "2"}, "", func(c *Context, v *bindTarget) error {
+			return c.BindHeader(v)
+		}, false},
+	}
+
+	for _, constructor := range []struct {
+		name string
+		new  func(...Option) *Engine
+	}{{"New", New}, {"Default", Default}} {
+		for _, tt := range tests {
+			t.Run(constructor.name+"/"+tt.name, func(t *testing.T) {
+				var got bindTarget
+				var err error
+				e := constructor.new()
+				e.Any("/", func(c *Context) error {
+					err = tt.bind(c, &got)
+					return nil
+				})
+
+				r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+				for k, v := range tt.header {
+					r.Header.Set(k, v)
+				}
+				e.ServeHTTP(httptest.NewRecorder(), r)
+
+				if tt.wantErr {
+					if err == nil {
+						t.Fatal("expected an error")
+					}
+					return
+				}
+				if err != nil || got != (bindTarget{Name: "a", Count: 2}) {
+					t.Fatalf("got %+v, %v", got, err)
+				}
+			})
+		}
+	}
+}
+
+type validatorFunc func(interface{}) error
+
+func (f validatorFunc) Validate(obj interface{}) error {
+	return f(obj)
+}
+
+func TestValidate(t *testing.T) {
+	errInvalid := errors.New("invalid")
+
+	tests := []struct {
+		name string
+		opts []Option
+		want error
+	}{
+		{"no validator", nil, ErrValidatorNotRegistered},
+		{"validator", []Option{WithValidator(validatorFunc(func(interface{}) error { return errInvalid }))}, errInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			e := New(tt.opts...)
+			e.GET("/", func(c *Context) error {
+				err = c.Validate(&bindTarget{})
+				return nil
+			})
+			performRequest(e, http.MethodGet, "/")
+			if err != tt.want {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOptions(t *testing.T) {
+	binder := DefaultBinder()
+	binder.Body = nil
+	errorHandler := func(c *Context, err error) {}
+
+	tests := []struct {
+		name  string
+		opt   Option
+		check func(*Engine) bool
+	}{
+		{"WithBinder", WithBinder(binder), func(e *Engine) bool { return e.binder.Body == nil }},
+		{"WithValidator", WithValidator(validatorFunc(nil)), func(e *Engine) bool { return e.validator != nil }},
+		{"WithErrorHandler", WithErrorHandler(errorHandler), func(e *Engine) bool { return e.errorHandler != nil }},
+		{"WithMaxMultipartMemory", WithMaxMultipartMemory(1 << 10), func(e *Engine) bool { return e.maxMultipartMemory == 1<<10 }},
+		{"WithMaxBodySize", WithMaxBodySize(1 << 10), func(e *Engine) bool { return e.maxBodySize == 1<<10 }},
+		{"WithRedirectTrailingSlash", WithRedirectTrailingSlash(false), func(e *Engine) bool { return !e.redirectTrailingSlash }},
+		{"WithHandleMethodNotAllowed", WithHandleMethodNotAllowed(false), func(e *Engine) bool { return !e.handleMethodNotAllowed }},
+		{"WithHandleOPTIONS", WithHandleOPTIONS(false), func(e *Engine) bool { return !e.handleOPTIONS }},
+		{"WithTrustedProxies", WithTrustedProxies("10.0.0.1", "192.168.0.0/16"), func(e *Engine) bool {
+			return len(e.trustedProxies) == 2 && e.trustedProxies[0].String() == "10.0.0.1/32"
+		}},
+		{"WithShutdownTimeout", WithShutdownTimeout(time.Second), func(e *Engine) bool { return e.shutdownTimeout == time.Second }},
+		{"WithShutdownSignals", WithShutdownSignals(os.Interrupt), func(e *Engine) bool {
+			return len(e.shutdownSignals) == 1 && e.shutdownSignals[0] == os.Interrupt
+		}},
+		{"WithReadHeaderTimeout", WithReadHeaderTimeout(time.Second), func(e *Engine) bool { return e.readHeaderTimeout == time.Second }},
+		{"WithShutdownSignals none", WithShutdownSignals(), func(e *Engine) bool { return len(e.shutdownSignals) == 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.check(New(tt.opt)) {
+				t.Error("option not applied")
+			}
+		})
+	}
+}
+
+func TestWithTrustedProxiesPanicsOnInvalidProxy(t *testing.T) {
+	for _, proxy := range []string{"10.0.0", "10.0.0.0/33"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", proxy)
+				}
+			}()
+			WithTrustedProxies(proxy)
+		}()
+	}
+}
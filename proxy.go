@@ -0,0 +1,497 @@
+package pisces
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xdatk/pisces/internal/constant"
+)
+
+const (
+	defaultProxyHealthCheckInterval = 10 * time.Second
+	defaultProxyHealthCheckTimeout  = 2 * time.Second
+	defaultProxyMaxFails            = 3
+	defaultProxyEjectionTime        = 30 * time.Second
+)
+
+// ProxyTarget is an upstream of a ReverseProxy.
+type ProxyTarget struct {
+	url *url.URL
+
+	unhealthy    int32
+	inFlight     int64
+	fails        int32
+	ejectedUntil int64
+}
+
+// URL returns the URL of the target.
+func (t *ProxyTarget) URL() *url.URL {
+	return t.url
+}
+
+// InFlight returns the number of requests the target is serving.
+func (t *ProxyTarget) InFlight() int64 {
+	return atomic.LoadInt64(&t.inFlight)
+}
+
+// Available reports whether the target passes its health checks and is not ejected.
+func (t *ProxyTarget) Available() bool {
+	return atomic.LoadInt32(&t.unhealthy) == 0 && time.Now().UnixNano() >= atomic.LoadInt64(&t.ejectedUntil)
+}
+
+// ProxyBalancer selects the target of a request among the available ones,
+// it must be safe for concurrent use.
+type ProxyBalancer interface {
+	Next(c *Context, targets []*ProxyTarget) *ProxyTarget
+}
+
+type roundRobinBalancer struct {
+	next uint64
+}
+
+// RoundRobinBalancer returns a ProxyBalancer selecting the targets in turn.
+func RoundRobinBalancer() ProxyBalancer {
+	return &roundRobinBalancer{}
+}
+
+func (b *roundRobinBalancer) Next(_ *Context, targets []*ProxyTarget) *ProxyTarget {
+	n := atomic.AddUint64(&b.next, 1) - 1
+	return targets[n%uint64(len(targets))]
+}
+
+type randomBalancer struct{}
+
+// RandomBalancer returns a ProxyBalancer selecting a target at random.
+func RandomBalancer() ProxyBalancer {
+	return randomBalancer{}
+}
+
+func (randomBalancer) Next(_ *Context, targets []*ProxyTarget) *ProxyTarget {
+	return targets[rand.Intn(len(targets))]
+}
+
+type leastConnBalancer struct {
+	next uint64
+}
+
+// LeastConnBalancer returns a ProxyBalancer selecting the target serving the fewest requests.
+func LeastConnBalancer() ProxyBalancer {
+	return &leastConnBalancer{}
+}
+
+func (b *leastConnBalancer) Next(_ *Context, targets []*ProxyTarget) *ProxyTarget {
+	// the ties are broken in turn, not always in favor of the first target
+	start := int((atomic.AddUint64(&b.next, 1) - 1) % uint64(len(targets)))
+	best := targets[start]
+	for i := 1; i < len(targets); i++ {
+		t := targets[(start+i)%len(targets)]
+		if t.InFlight() < best.InFlight() {
+			best = t
+		}
+	}
+	return best
+}
+
+type consistentHashBalancer struct {
+	keyFunc func(*Context) string
+}
+
+// ConsistentHashBalancer returns a ProxyBalancer selecting the same target for the requests
+// with the same key, default the client IP, see Context.RealIP. Only the requests of a target
+// becoming unavailable move to other targets.
+func ConsistentHashBalancer(keyFunc func(*Context) string) ProxyBalancer {
+	if keyFunc == nil {
+		keyFunc = func(c *Context) string {
+			return c.RealIP()
+		}
+	}
+	return &consistentHashBalancer{keyFunc: keyFunc}
+}
+
+// Next selects the target with the highest hash of the key and its URL, rendezvous hashing.
+func (b *consistentHashBalancer) Next(c *Context, targets []*ProxyTarget) *ProxyTarget {
+	key := b.keyFunc(c)
+
+	var best *ProxyTarget
+	var bestScore uint64
+	for _, t := range targets {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(t.url.String()))
+		if score := h.Sum64(); best == nil || score > bestScore {
+			best, bestScore = t, score
+		}
+	}
+	return best
+}
+
+// ProxyRewrite is a path rewrite rule of a ReverseProxy. From is matched against the whole
+// request path, each "*" matching any characters, which are available to To as $1, $2...
+// For example {"/api/*", "/v2/$1"}.
+type ProxyRewrite struct {
+	From string
+	To   string
+}
+
+// ProxyHealthCheck defines the active health checks of a ReverseProxy.
+type ProxyHealthCheck struct {
+	// Path is requested on every target, a 2xx or 3xx status marks it healthy.
+	// An empty path disables the active health checks.
+	Path string
+
+	// Interval is the time between two checks, default 10 seconds.
+	Interval time.Duration
+
+	// Timeout is the timeout of a check, default 2 seconds.
+	Timeout time.Duration
+}
+
+// ProxyConfig defines the config for ReverseProxy.
+type ProxyConfig struct {
+	// Targets are the URLs of the upstreams, for example "http://10.0.0.1:8080/base", required.
+	Targets []string
+
+	// Balancer selects the target of a request, default RoundRobinBalancer.
+	Balancer ProxyBalancer
+
+	// Rewrite are the path rewrite rules, the first matching one applies.
+	Rewrite []ProxyRewrite
+
+	// HealthCheck defines the active health checks.
+	HealthCheck ProxyHealthCheck
+
+	// MaxFails is the number of consecutive failures, errors or 5xx responses, after which a
+	// target is ejected for EjectionTime, default 3. A negative value disables the ejection.
+	MaxFails int
+
+	// EjectionTime is how long a failing target is ejected, default 30 seconds.
+	EjectionTime time.Duration
+
+	// PreserveHost forwards the Host header of the request instead of the one of the target.
+	PreserveHost bool
+
+	// Transport performs the upstream requests, default http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// FlushInterval is the flush interval of the response body, see httputil.ReverseProxy.
+	FlushInterval time.Duration
+
+	// ModifyResponse modifies the upstream response, see httputil.ReverseProxy.
+	ModifyResponse func(*http.Response) error
+}
+
+// ReverseProxy forwards requests to a set of upstreams.
+type ReverseProxy struct {
+	config   ProxyConfig
+	targets  []*ProxyTarget
+	rewrites []proxyRewrite
+	proxy    *httputil.ReverseProxy
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+type proxyRewrite struct {
+	from *regexp.Regexp
+	to   string
+}
+
+// proxyAttempt is the state of a request forwarded by a ReverseProxy.
+type proxyAttempt struct {
+	c      *Context
+	target *ProxyTarget
+	path   string
+	err    error
+	// responded reports whether the target responded, its status is then recorded
+	responded bool
+}
+
+type proxyAttemptKey struct{}
+
+// Proxy returns a handler forwarding requests to targets in turn, see NewReverseProxy.
+// It panics if a target is not a valid URL.
+//
+// Routes forwarding every path under a prefix can use a wildcard, for example:
+//
+//	api := e.Group("/api", pisces.KeyAuth(validator))
+//	api.Any("/*path", pisces.Proxy("http://10.0.0.1:8080", "http://10.0.0.2:8080"))
+func Proxy(targets ...string) HandlerFunc {
+	return ProxyWithConfig(ProxyConfig{Targets: targets})
+}
+
+// ProxyWithConfig returns a handler forwarding requests with config, see NewReverseProxy.
+// It panics if the config is invalid, or enables the active health checks, which only stop
+// when the ReverseProxy returned by NewReverseProxy is closed.
+func ProxyWithConfig(config ProxyConfig) HandlerFunc {
+	if config.HealthCheck.Path != "" {
+		panic("proxy health checks require NewReverseProxy")
+	}
+	p, err := NewReverseProxy(config)
+	if err != nil {
+		panic(err)
+	}
+	return p.Handler
+}
+
+// NewReverseProxy returns a ReverseProxy configured by config, which runs its active health
+// checks until it is closed.
+//
+// The X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto headers of the request are
+// extended if it comes from a trusted proxy, see WithTrustedProxies, and replaced otherwise.
+// Upgrade requests, such as WebSocket handshakes, are passed through by hijacking the connection.
+func NewReverseProxy(config ProxyConfig) (*ReverseProxy, error) {
+	if len(config.Targets) == 0 {
+		return nil, errors.New("proxy: no target")
+	}
+	if config.Balancer == nil {
+		config.Balancer = RoundRobinBalancer()
+	}
+	if config.MaxFails == 0 {
+		config.MaxFails = defaultProxyMaxFails
+	}
+	if config.EjectionTime <= 0 {
+		config.EjectionTime = defaultProxyEjectionTime
+	}
+	if config.HealthCheck.Interval <= 0 {
+		config.HealthCheck.Interval = defaultProxyHealthCheckInterval
+	}
+	if config.HealthCheck.Timeout <= 0 {
+		config.HealthCheck.Timeout = defaultProxyHealthCheckTimeout
+	}
+	if config.Transport == nil {
+		config.Transport = http.DefaultTransport
+	}
+
+	p := &ReverseProxy{
+		config: config,
+		closed: make(chan struct{}),
+	}
+
+	for _, target := range config.Targets {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: invalid target %q: %w", target, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("proxy: invalid target %q", target)
+		}
+		p.targets = append(p.targets, &ProxyTarget{url: u})
+	}
+
+	for _, r := range config.Rewrite {
+		pattern := "^" + strings.ReplaceAll(regexp.QuoteMeta(r.From), `\*`, "(.*)") + "$"
+		from, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: invalid rewrite %q: %w", r.From, err)
+		}
+		p.rewrites = append(p.rewrites, proxyRewrite{from: from, to: r.To})
+	}
+
+	p.proxy = &httputil.ReverseProxy{
+		Director:       p.direct,
+		Transport:      config.Transport,
+		FlushInterval:  config.FlushInterval,
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler:   p.handleError,
+	}
+
+	if config.HealthCheck.Path != "" {
+		go p.checkHealth()
+	}
+	return p, nil
+}
+
+// Targets returns the targets of the proxy.
+func (p *ReverseProxy) Targets() []*ProxyTarget {
+	return p.targets
+}
+
+// Close stops the active health checks.
+func (p *ReverseProxy) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+	return nil
+}
+
+// Handler forwards the request to a target, it fails with ErrServiceUnavailable if none is
+// available and with a 502 or 504 error if the target does not respond.
+func (p *ReverseProxy) Handler(c *Context) error {
+	available := make([]*ProxyTarget, 0, len(p.targets))
+	for _, t := range p.targets {
+		if t.Available() {
+			available = append(available, t)
+		}
+	}
+	if len(available) == 0 {
+		return ErrServiceUnavailable
+	}
+
+	target := p.config.Balancer.Next(c, available)
+	atomic.AddInt64(&target.inFlight, 1)
+	defer atomic.AddInt64(&target.inFlight, -1)
+
+	attempt := &proxyAttempt{c: c, target: target, path: p.rewrite(c.Request.URL.Path)}
+	req := c.Request.WithContext(context.WithValue(c.Request.Context(), proxyAttemptKey{}, attempt))
+	p.proxy.ServeHTTP(c.Writer, req)
+
+	if attempt.err == nil {
+		return nil
+	}
+	if errors.Is(attempt.err, context.Canceled) && c.Request.Context().Err() != nil {
+		// the client went away, it is not the failure of the target
+		return nil
+	}
+
+	if !attempt.responded {
+		// the status of a target which responded is recorded by modifyResponse
+		p.fail(target)
+	}
+	if errors.Is(attempt.err, context.DeadlineExceeded) {
+		return NewHTTPError(http.StatusGatewayTimeout).SetInternal(attempt.err)
+	}
+	return NewHTTPError(ErrBadGateway.Code, ErrBadGateway.Message).SetInternal(attempt.err)
+}
+
+func (p *ReverseProxy) rewrite(path string) string {
+	for _, r := range p.rewrites {
+		if r.from.MatchString(path) {
+			return r.from.ReplaceAllString(path, r.to)
+		}
+	}
+	return path
+}
+
+// direct rewrites the outgoing request for the target of its attempt.
+func (p *ReverseProxy) direct(req *http.Request) {
+	attempt := req.Context().Value(proxyAttemptKey{}).(*proxyAttempt)
+	c, target := attempt.c, attempt.target.url
+
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	req.URL.Path = joinURLPath(target.Path, attempt.path)
+	req.URL.RawPath = ""
+	if target.RawQuery == "" || req.URL.RawQuery == "" {
+		req.URL.RawQuery = target.RawQuery + req.URL.RawQuery
+	} else {
+		req.URL.RawQuery = target.RawQuery + "&" + req.URL.RawQuery
+	}
+	if !p.config.PreserveHost {
+		req.Host = target.Host
+	}
+
+	// the forwarded headers can only be trusted from a trusted proxy, httputil.ReverseProxy
+	// then appends the address of the peer to X-Forwarded-For
+	if !c.fromTrustedProxy() {
+		req.Header.Del(constant.HeaderForwarded)
+		req.Header.Del(constant.HeaderXForwardedFor)
+		req.Header.Del(constant.HeaderXForwardedHost)
+		req.Header.Del(constant.HeaderXForwardedProto)
+	}
+	if req.Header.Get(constant.HeaderXForwardedHost) == "" {
+		req.Header.Set(constant.HeaderXForwardedHost, c.Request.Host)
+	}
+	req.Header.Set(constant.HeaderXForwardedProto, c.Scheme())
+}
+
+func (p *ReverseProxy) modifyResponse(res *http.Response) error {
+	attempt := res.Request.Context().Value(proxyAttemptKey{}).(*proxyAttempt)
+	attempt.responded = true
+	if res.StatusCode >= http.StatusInternalServerError {
+		p.fail(attempt.target)
+	} else {
+		atomic.StoreInt32(&attempt.target.fails, 0)
+	}
+
+	if p.config.ModifyResponse != nil {
+		return p.config.ModifyResponse(res)
+	}
+	return nil
+}
+
+// handleError records the error of the attempt, Handler returns it once ServeHTTP returns.
+func (p *ReverseProxy) handleError(_ http.ResponseWriter, req *http.Request, err error) {
+	attempt := req.Context().Value(proxyAttemptKey{}).(*proxyAttempt)
+	attempt.err = err
+}
+
+// fail records a failure of target, ejecting it after MaxFails consecutive ones.
+func (p *ReverseProxy) fail(target *ProxyTarget) {
+	if p.config.MaxFails < 0 {
+		return
+	}
+	if atomic.AddInt32(&target.fails, 1) >= int32(p.config.MaxFails) {
+		atomic.StoreInt32(&target.fails, 0)
+		atomic.StoreInt64(&target.ejectedUntil, time.Now().Add(p.config.EjectionTime).UnixNano())
+	}
+}
+
+func (p *ReverseProxy) checkHealth() {
+	client := &http.Client{
+		Transport: p.config.Transport,
+		Timeout:   p.config.HealthCheck.Timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	ticker := time.NewTicker(p.config.HealthCheck.Interval)
+	defer ticker.Stop()
+
+	for {
+		var wg sync.WaitGroup
+		for _, t := range p.targets {
+			wg.Add(1)
+			go func(t *ProxyTarget) {
+				defer wg.Done()
+				unhealthy := int32(1)
+				if p.healthy(client, t) {
+					unhealthy = 0
+				}
+				atomic.StoreInt32(&t.unhealthy, unhealthy)
+			}(t)
+		}
+		wg.Wait()
+
+		select {
+		case <-p.closed:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *ReverseProxy) healthy(client *http.Client, t *ProxyTarget) bool {
+	u := *t.url
+	u.Path = joinURLPath(u.Path, p.config.HealthCheck.Path)
+	u.RawPath = ""
+
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return false
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
+
+func joinURLPath(base, path string) string {
+	switch {
+	case base == "":
+		return path
+	case path == "":
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
@@ -0,0 +1,279 @@
+package pisces
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newProxyUpstream(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s?%s", name, r.URL.Path, r.URL.RawQuery)
+	}))
+}
+
+func TestProxyBalancers(t *testing.T) {
+	a, b := newProxyUpstream("a"), newProxyUpstream("b")
+	defer a.Close()
+	defer b.Close()
+
+	tests := []struct {
+		name     string
+		balancer ProxyBalancer
+		check    func(t *testing.T, hits map[string]int)
+	}{
+		{"round robin", RoundRobinBalancer(), func(t *testing.T, hits map[string]int) {
+			if hits["a"] != 5 || hits["b"] != 5 {
+				t.Errorf("got %v, want 5 hits each", hits)
+			}
+		}},
+		{"least connections", LeastConnBalancer(), func(t *testing.T, hits map[string]int) {
+			if hits["a"] != 5 || hits["b"] != 5 {
+				t.Errorf("got %v, want 5 hits each", hits)
+			}
+		}},
+		{"random", RandomBalancer(), func(t *testing.T, hits map[string]int) {
+			if hits["a"]+hits["b"] != 10 {
+				t.Errorf("got %v, want 10 hits", hits)
+			}
+		}},
+		{"consistent hash", ConsistentHashBalancer(nil), func(t *testing.T, hits map[string]int) {
+			if hits["a"] != 10 && hits["b"] != 10 {
+				t.Errorf("got %v, want every hit on the same target", hits)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := New()
+			e.Any("/*path", ProxyWithConfig(ProxyConfig{
+				Targets:  []string{a.URL, b.URL},
+				Balancer: tt.balancer,
+			}))
+
+			hits := make(map[string]int)
+			for i := 0; i < 10; i++ {
+				w := performRequest(e, http.MethodGet, "/users?id=1")
+				if w.Code != http.StatusOK {
+					t.Fatalf("got status %d", w.Code)
+				}
+				hits[strings.Fields(w.Body.String())[0]]++
+			}
+			tt.check(t, hits)
+		})
+	}
+}
+
+func TestProxyRewriteAndForwardedHeaders(t *testing.T) {
+	var got *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer upstream.Close()
+
+	e := New(WithTrustedProxies("10.0.0.1"))
+	e.Any("/*path", ProxyWithConfig(ProxyConfig{
+		Targets: []string{upstream.URL + "/base"},
+		Rewrite: []ProxyRewrite{{From: "/api/*", To: "/v2/$1"}},
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		path       string
+		wantPath   string
+		wantFor    string
+	}{
+		{"untrusted peer", "192.0.2.1:1234", "/api/users", "/base/v2/users", "192.0.2.1"},
+		{"trusted peer", "10.0.0.1:1234", "/other", "/base/other", "198.51.100.1, 10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://example.com"+tt.path+"?q=1", nil)
+			r.RemoteAddr = tt.remoteAddr
+			r.Header.Set("X-Forwarded-For", "198.51.100.1")
+			r.Header.Set("X-Forwarded-Proto", "https")
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d", w.Code)
+			}
+			if got.URL.Path != tt.wantPath || got.URL.RawQuery != "q=1" {
+				t.Errorf("got %s?%s, want %s?q=1", got.URL.Path, got.URL.RawQuery, tt.wantPath)
+			}
+			if v := got.Header.Get("X-Forwarded-For"); v != tt.wantFor {
+				t.Errorf("got X-Forwarded-For %q, want %q", v, tt.wantFor)
+			}
+			if v := got.Header.Get("X-Forwarded-Host"); v != "example.com" {
+				t.Errorf("got X-Forwarded-Host %q", v)
+			}
+			wantProto := "http"
+			if tt.remoteAddr == "10.0.0.1:1234" {
+				wantProto = "https"
+			}
+			if v := got.Header.Get("X-Forwarded-Proto"); v != wantProto {
+				t.Errorf("got X-Forwarded-Proto %q, want %q", v, wantProto)
+			}
+		})
+	}
+}
+
+func TestProxyEjectsFailingTargets(t *testing.T) {
+	healthy := newProxyUpstream("healthy")
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	p, err := NewReverseProxy(ProxyConfig{
+		Targets:  []string{healthy.URL, failing.URL, down.URL},
+		MaxFails: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := New()
+	e.Any("/*path", p.Handler)
+
+	codes := make(map[int]int)
+	for i := 0; i < 9; i++ {
+		codes[performRequest(e, http.MethodGet, "/").Code]++
+	}
+	if codes[http.StatusInternalServerError] != 1 || codes[http.StatusBadGateway] != 1 || codes[http.StatusOK] != 7 {
+		t.Errorf("got status counts %v", codes)
+	}
+
+	for _, target := range p.Targets()[1:] {
+		if target.Available() {
+			t.Errorf("target %s is not ejected", target.URL())
+		}
+	}
+}
+
+func TestProxyCountsModifyResponseErrorsOnce(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	p, err := NewReverseProxy(ProxyConfig{
+		Targets:  []string{failing.URL},
+		MaxFails: 2,
+		ModifyResponse: func(*http.Response) error {
+			return errors.New("rejected")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := New()
+	e.Any("/*path", p.Handler)
+
+	if w := performRequest(e, http.MethodGet, "/"); w.Code != http.StatusBadGateway {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadGateway)
+	}
+	if !p.Targets()[0].Available() {
+		t.Fatal("one failure ejected the target")
+	}
+	performRequest(e, http.MethodGet, "/")
+	if p.Targets()[0].Available() {
+		t.Error("two failures did not eject the target")
+	}
+}
+
+func TestProxyHealthCheck(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			w.WriteHeader(status)
+		}
+	}))
+	defer upstream.Close()
+
+	p, err := NewReverseProxy(ProxyConfig{
+		Targets:     []string{upstream.URL},
+		HealthCheck: ProxyHealthCheck{Path: "/healthz", Interval: time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	e := New()
+	e.Any("/*path", p.Handler)
+
+	deadline := time.Now().Add(time.Second)
+	for p.Targets()[0].Available() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if w := performRequest(e, http.MethodGet, "/"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestProxyWithConfigRejectsHealthChecks(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+	ProxyWithConfig(ProxyConfig{Targets: []string{"http://127.0.0.1:1"}, HealthCheck: ProxyHealthCheck{Path: "/healthz"}})
+}
+
+func TestProxyUpgrade(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = rw.Flush()
+		line, _ := rw.ReadString('\n')
+		_, _ = rw.WriteString("echo " + line)
+		_ = rw.Flush()
+	}))
+	defer upstream.Close()
+
+	e := New()
+	e.GET("/ws", Proxy(upstream.URL))
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, _ = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d", res.StatusCode)
+	}
+
+	_, _ = io.WriteString(conn, "ping\n")
+	line, err := br.ReadString('\n')
+	if err != nil || line != "echo ping\n" {
+		t.Errorf("got %q, %v", line, err)
+	}
+}
@@ -0,0 +1,342 @@
+package pisces
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xdatk/pisces/internal/constant"
+)
+
+const (
+	defaultRateLimitShards = 32
+)
+
+// RateLimitResult is the outcome of taking a request from the limit of a key.
+type RateLimitResult struct {
+	// Allowed reports whether the request is allowed.
+	Allowed bool
+	// Limit is the number of requests allowed in a window.
+	Limit int
+	// Remaining is the number of requests still allowed.
+	Remaining int
+	// Reset is the duration until the limit is fully available again.
+	Reset time.Duration
+	// RetryAfter is the duration until the next request is allowed, if this one is not.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore takes requests from the limits of keys, it must be safe for concurrent use.
+// It can be implemented on top of an external backend shared by several instances.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string) (RateLimitResult, error)
+}
+
+// RateLimitKeyFunc extracts the key a request is limited by, such as the client IP, a header
+// or an authenticated principal.
+type RateLimitKeyFunc func(*Context) (string, error)
+
+// RateLimitConfig defines the config for RateLimit middleware.
+type RateLimitConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+
+	// Store keeps the limits, required.
+	Store RateLimitStore
+
+	// KeyFunc extracts the key a request is limited by, default RateLimitKeyByRealIP.
+	KeyFunc RateLimitKeyFunc
+
+	// DenyHandler is called when a request is not allowed, default returns ErrTooManyRequests.
+	DenyHandler func(*Context, RateLimitResult) error
+}
+
+// DefaultRateLimitConfig is the default RateLimit middleware config.
+var DefaultRateLimitConfig = RateLimitConfig{
+	Skipper: DefaultSkipper,
+	KeyFunc: RateLimitKeyByRealIP,
+	DenyHandler: func(*Context, RateLimitResult) error {
+		return ErrTooManyRequests
+	},
+}
+
+// RateLimitKeyByRealIP limits requests by Context.RealIP.
+func RateLimitKeyByRealIP(c *Context) (string, error) {
+	return c.RealIP(), nil
+}
+
+// RateLimitKeyByRoute limits requests by method and route template, see Context.FullPath.
+func RateLimitKeyByRoute(c *Context) (string, error) {
+	return c.Method() + " " + c.fullPath, nil
+}
+
+// RateLimitKeyByHeader limits requests by the value of the given request header,
+// requests without it fail with ErrBadRequest.
+func RateLimitKeyByHeader(name string) RateLimitKeyFunc {
+	return func(c *Context) (string, error) {
+		key := c.Header(name)
+		if key == "" {
+			return "", ErrBadRequest
+		}
+		return key, nil
+	}
+}
+
+// RateLimit returns a middleware which limits requests by client IP with the given store.
+// The RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers are set on every response,
+// Retry-After is set on the responses of requests which are not allowed.
+func RateLimit(store RateLimitStore) MiddlewareFunc {
+	config := DefaultRateLimitConfig
+	config.Store = store
+	return RateLimitWithConfig(config)
+}
+
+// RateLimitWithConfig returns a RateLimit middleware with config, see RateLimit.
+func RateLimitWithConfig(config RateLimitConfig) MiddlewareFunc {
+	if config.Store == nil {
+		panic("rate limit store must not be nil")
+	}
+	if config.Skipper == nil {
+		config.Skipper = DefaultRateLimitConfig.Skipper
+	}
+	if config.KeyFunc == nil {
+		config.KeyFunc = DefaultRateLimitConfig.KeyFunc
+	}
+	if config.DenyHandler == nil {
+		config.DenyHandler = DefaultRateLimitConfig.DenyHandler
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			key, err := config.KeyFunc(c)
+			if err != nil {
+				return err
+			}
+
+			result, err := config.Store.Take(c.Request.Context(), key)
+			if err != nil {
+				return err
+			}
+
+			header := c.Writer.Header()
+			header.Set(constant.HeaderRateLimitLimit, strconv.Itoa(result.Limit))
+			header.Set(constant.HeaderRateLimitRemaining, strconv.Itoa(result.Remaining))
+			header.Set(constant.HeaderRateLimitReset, strconv.FormatInt(ceilSeconds(result.Reset), 10))
+
+			if !result.Allowed {
+				retryAfter := ceilSeconds(result.RetryAfter)
+				if retryAfter < 1 {
+					retryAfter = 1
+				}
+				header.Set(constant.HeaderRetryAfter, strconv.FormatInt(retryAfter, 10))
+				return config.DenyHandler(c, result)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// RateLimitAlgorithm is the algorithm used by MemoryRateLimitStore.
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket refills Limit tokens per Window, up to Burst, and takes one per request.
+	TokenBucket RateLimitAlgorithm = iota
+	// SlidingWindow allows Limit requests per Window, estimating the requests of the sliding
+	// window from the counts of the current and previous fixed windows.
+	SlidingWindow
+)
+
+// MemoryRateLimitStoreConfig defines the config for MemoryRateLimitStore.
+type MemoryRateLimitStoreConfig struct {
+	// Algorithm is the limiting algorithm, default TokenBucket.
+	Algorithm RateLimitAlgorithm
+
+	// Limit is the number of requests allowed per Window, required.
+	Limit int
+
+	// Window is the duration Limit applies to, default one second.
+	Window time.Duration
+
+	// Burst is the capacity of the token bucket, default Limit.
+	Burst int
+
+	// Shards is the number of independently locked shards, default 32.
+	Shards int
+
+	// ExpiresIn is how long a key can stay idle before being evicted, default two windows.
+	ExpiresIn time.Duration
+}
+
+// MemoryRateLimitStore is an in-memory RateLimitStore, sharded by key.
+type MemoryRateLimitStore struct {
+	algorithm RateLimitAlgorithm
+	limit     int
+	window    time.Duration
+	burst     int
+	expiresIn time.Duration
+	shards    []rateLimitShard
+
+	// now is replaced by tests.
+	now func() time.Time
+}
+
+type rateLimitShard struct {
+	mu        sync.Mutex
+	entries   map[string]*rateLimitEntry
+	lastSweep time.Time
+}
+
+type rateLimitEntry struct {
+	lastSeen time.Time
+
+	// token bucket
+	tokens float64
+
+	// sliding window
+	start time.Time
+	prev  int
+	curr  int
+}
+
+// NewMemoryRateLimitStore returns a MemoryRateLimitStore configured by config.
+func NewMemoryRateLimitStore(config MemoryRateLimitStoreConfig) *MemoryRateLimitStore {
+	if config.Limit <= 0 {
+		panic("rate limit must be positive")
+	}
+	if config.Window <= 0 {
+		config.Window = time.Second
+	}
+	if config.Burst <= 0 {
+		config.Burst = config.Limit
+	}
+	if config.Shards <= 0 {
+		config.Shards = defaultRateLimitShards
+	}
+	if config.ExpiresIn <= 0 {
+		config.ExpiresIn = 2 * config.Window
+	}
+
+	s := &MemoryRateLimitStore{
+		algorithm: config.Algorithm,
+		limit:     config.Limit,
+		window:    config.Window,
+		burst:     config.Burst,
+		expiresIn: config.ExpiresIn,
+		shards:    make([]rateLimitShard, config.Shards),
+		now:       time.Now,
+	}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]*rateLimitEntry)
+	}
+	return s
+}
+
+// Take implements RateLimitStore.
+func (s *MemoryRateLimitStore) Take(_ context.Context, key string) (RateLimitResult, error) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	shard := &s.shards[h.Sum32()%uint32(len(s.shards))]
+
+	now := s.now()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if now.Sub(shard.lastSweep) > s.expiresIn {
+		for k, e := range shard.entries {
+			if now.Sub(e.lastSeen) > s.expiresIn {
+				delete(shard.entries, k)
+			}
+		}
+		shard.lastSweep = now
+	}
+
+	e, ok := shard.entries[key]
+	if !ok {
+		e = &rateLimitEntry{tokens: float64(s.burst), start: now.Truncate(s.window)}
+		shard.entries[key] = e
+	}
+
+	var result RateLimitResult
+	if s.algorithm == SlidingWindow {
+		result = s.takeSlidingWindow(e, now)
+	} else {
+		result = s.takeTokenBucket(e, now)
+	}
+	e.lastSeen = now
+	return result, nil
+}
+
+func (s *MemoryRateLimitStore) takeTokenBucket(e *rateLimitEntry, now time.Time) RateLimitResult {
+	// tokens refilled per nanosecond
+	rate := float64(s.limit) / float64(s.window)
+
+	if !e.lastSeen.IsZero() {
+		e.tokens = math.Min(float64(s.burst), e.tokens+float64(now.Sub(e.lastSeen))*rate)
+	}
+
+	result := RateLimitResult{Limit: s.burst}
+	if e.tokens >= 1 {
+		e.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - e.tokens) / rate)
+	}
+
+	result.Remaining = int(e.tokens)
+	result.Reset = time.Duration((float64(s.burst) - e.tokens) / rate)
+	return result
+}
+
+func (s *MemoryRateLimitStore) takeSlidingWindow(e *rateLimitEntry, now time.Time) RateLimitResult {
+	start := now.Truncate(s.window)
+	if start != e.start {
+		if start.Sub(e.start) == s.window {
+			e.prev = e.curr
+		} else {
+			e.prev = 0
+		}
+		e.curr = 0
+		e.start = start
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(s.window)
+	estimate := float64(e.prev)*weight + float64(e.curr)
+
+	result := RateLimitResult{Limit: s.limit}
+	if estimate+1 <= float64(s.limit) {
+		e.curr++
+		estimate++
+		result.Allowed = true
+	} else if e.curr+1 > s.limit || e.prev == 0 {
+		result.RetryAfter = s.window - elapsed
+	} else {
+		// the weight of the previous window must drop to (limit - curr - 1) / prev
+		target := 1 - float64(s.limit-e.curr-1)/float64(e.prev)
+		result.RetryAfter = time.Duration(target*float64(s.window)) - elapsed
+	}
+
+	result.Remaining = s.limit - int(math.Ceil(estimate))
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+	result.Reset = 2*s.window - elapsed
+	if e.prev == 0 {
+		result.Reset = s.window - elapsed
+	}
+	return result
+}
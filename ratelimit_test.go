@@ -0,0 +1,164 @@
+package pisces
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (f *fakeClock) now() time.Time {
+	return f.t
+}
+
+func (f *fakeClock) advance(d time.Duration) {
+	f.t = f.t.Add(d)
+}
+
+func TestMemoryRateLimitStoreTokenBucket(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	s := NewMemoryRateLimitStore(MemoryRateLimitStoreConfig{Limit: 2, Window: time.Second, Burst: 3})
+	s.now = clock.now
+
+	take := func() RateLimitResult {
+		r, err := s.Take(context.Background(), "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	for i := 2; i >= 0; i-- {
+		if r := take(); !r.Allowed || r.Remaining != i || r.Limit != 3 {
+			t.Fatalf("burst request %d: got %+v", 3-i, r)
+		}
+	}
+	r := take()
+	if r.Allowed || r.RetryAfter.Round(time.Millisecond) != 500*time.Millisecond || r.Reset.Round(time.Millisecond) != 1500*time.Millisecond {
+		t.Fatalf("got %+v after the burst", r)
+	}
+
+	// two tokens are refilled per second
+	clock.advance(500 * time.Millisecond)
+	if r := take(); !r.Allowed || r.Remaining != 0 {
+		t.Fatalf("got %+v after one token was refilled", r)
+	}
+	clock.advance(time.Minute)
+	if r := take(); !r.Allowed || r.Remaining != 2 {
+		t.Fatalf("got %+v after refilling more than the burst", r)
+	}
+
+	// keys have their own bucket
+	if r, _ := s.Take(context.Background(), "other"); !r.Allowed || r.Remaining != 2 {
+		t.Fatalf("got %+v for another key", r)
+	}
+}
+
+func TestMemoryRateLimitStoreSlidingWindow(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	s := NewMemoryRateLimitStore(MemoryRateLimitStoreConfig{Algorithm: SlidingWindow, Limit: 4, Window: 10 * time.Second})
+	s.now = clock.now
+
+	take := func() RateLimitResult {
+		r, _ := s.Take(context.Background(), "k")
+		return r
+	}
+
+	for i := 0; i < 4; i++ {
+		if r := take(); !r.Allowed {
+			t.Fatalf("request %d: got %+v", i, r)
+		}
+	}
+	if r := take(); r.Allowed || r.RetryAfter != 10*time.Second || r.Remaining != 0 {
+		t.Fatalf("got %+v over the limit", r)
+	}
+
+	// half way through the next window, half of the previous count still applies
+	clock.advance(15 * time.Second)
+	for i := 0; i < 2; i++ {
+		if r := take(); !r.Allowed {
+			t.Fatalf("request %d of the next window: got %+v", i, r)
+		}
+	}
+	r := take()
+	if r.Allowed || r.RetryAfter != 2500*time.Millisecond {
+		t.Fatalf("got %+v over the sliding limit", r)
+	}
+
+	clock.advance(2500 * time.Millisecond)
+	if r := take(); !r.Allowed {
+		t.Fatalf("got %+v after RetryAfter", r)
+	}
+}
+
+func TestMemoryRateLimitStoreEvictsIdleKeys(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	s := NewMemoryRateLimitStore(MemoryRateLimitStoreConfig{Limit: 1, Window: time.Second, Shards: 1})
+	s.now = clock.now
+
+	s.Take(context.Background(), "idle")
+	clock.advance(time.Second)
+	s.Take(context.Background(), "active")
+	clock.advance(1500 * time.Millisecond)
+	s.Take(context.Background(), "active")
+
+	entries := s.shards[0].entries
+	if _, ok := entries["idle"]; ok || len(entries) != 1 {
+		t.Errorf("got entries %v, want only the active key", entries)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	store := NewMemoryRateLimitStore(MemoryRateLimitStoreConfig{Limit: 1, Window: 2 * time.Second})
+	store.now = clock.now
+
+	e := New()
+	e.Use(RateLimitWithConfig(RateLimitConfig{Store: store, KeyFunc: RateLimitKeyByHeader("X-API-Key")}))
+	e.GET("/", func(c *Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	request := func(key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		key        string
+		code       int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		{"a", http.StatusOK, "0", "2", ""},
+		{"a", http.StatusTooManyRequests, "0", "2", "2"},
+		{"b", http.StatusOK, "0", "2", ""},
+		{"", http.StatusBadRequest, "", "", ""},
+	}
+	for i, tt := range tests {
+		w := request(tt.key)
+		h := w.Header()
+		if w.Code != tt.code || h.Get("RateLimit-Remaining") != tt.remaining || h.Get("RateLimit-Reset") != tt.reset || h.Get("Retry-After") != tt.retryAfter {
+			t.Errorf("request %d: got %d and headers %v", i, w.Code, h)
+		}
+		if tt.code != http.StatusBadRequest && h.Get("RateLimit-Limit") != "1" {
+			t.Errorf("request %d: got RateLimit-Limit %q", i, h.Get("RateLimit-Limit"))
+		}
+	}
+
+	clock.advance(2 * time.Second)
+	if w := request("a"); w.Code != http.StatusOK {
+		t.Errorf("got status %d once the bucket was refilled", w.Code)
+	}
+}
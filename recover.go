@@ -0,0 +1,95 @@
+package pisces
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+)
+
+const (
+	defaultStackSize = 4 << 10 // 4 KB
+)
+
+// RecoverConfig defines the config for Recover middleware.
+type RecoverConfig struct {
+	// StackSize is the maximum size of the captured stack trace, default 4 KB.
+	StackSize int
+
+	// StackAll captures the stack traces of all the other goroutines too.
+	StackAll bool
+
+	// Reporter is called with every recovered panic, the default logs it with the log package.
+	Reporter func(*Context, *PanicError)
+}
+
+// DefaultRecoverConfig is the default Recover middleware config.
+var DefaultRecoverConfig = RecoverConfig{
+	StackSize: defaultStackSize,
+	Reporter:  logPanic,
+}
+
+// PanicError is the internal error of the HTTPError returned by Recover middleware.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+// Error makes it compatible with `error` interface.
+func (pe *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", pe.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (pe *PanicError) Unwrap() error {
+	if err, ok := pe.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Recover returns a middleware which recovers from panics anywhere in the chain
+// and turns them into 500 HTTPError handled by the engine's ErrorHandler.
+func Recover() MiddlewareFunc {
+	return RecoverWithConfig(DefaultRecoverConfig)
+}
+
+// RecoverWithConfig returns a Recover middleware with config, see Recover.
+//
+// http.ErrAbortHandler is panicked again, so net/http aborts the response as intended.
+func RecoverWithConfig(config RecoverConfig) MiddlewareFunc {
+	if config.StackSize <= 0 {
+		config.StackSize = DefaultRecoverConfig.StackSize
+	}
+	if config.Reporter == nil {
+		config.Reporter = DefaultRecoverConfig.Reporter
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) (err error) {
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+				if r == http.ErrAbortHandler {
+					panic(r)
+				}
+
+				stack := make([]byte, config.StackSize)
+				stack = stack[:runtime.Stack(stack, config.StackAll)]
+
+				pe := &PanicError{Value: r, Stack: stack}
+				config.Reporter(c, pe)
+
+				err = NewHTTPError(http.StatusInternalServerError).SetInternal(pe)
+			}()
+
+			return next(c)
+		}
+	}
+}
+
+func logPanic(c *Context, pe *PanicError) {
+	log.Printf("[PANIC RECOVER] %s %s: %v\n%s", c.Method(), c.Path(), pe.Value, pe.Stack)
+}
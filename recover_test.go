@@ -0,0 +1,88 @@
+package pisces
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	var buf bytes.Buffer
+	out := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(out)
+
+	var got error
+	e := New(WithErrorHandler(func(c *Context, err error) {
+		got = err
+		DefaultErrorHandler(c, err)
+	}))
+	e.Use(Recover())
+	errBoom := errors.New("boom")
+	e.GET("/", func(c *Context) error {
+		panic(errBoom)
+	})
+
+	w := performRequest(e, http.MethodGet, "/")
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d", w.Code)
+	}
+
+	var pe *PanicError
+	if !errors.As(got, &pe) || !errors.Is(got, errBoom) {
+		t.Fatalf("got error %v, want a PanicError wrapping %v", got, errBoom)
+	}
+	if !bytes.Contains(pe.Stack, []byte("TestRecover")) {
+		t.Errorf("stack does not contain the panicking function:\n%s", pe.Stack)
+	}
+	if out := buf.String(); !strings.Contains(out, "[PANIC RECOVER] GET /: boom") || !strings.Contains(out, "TestRecover") {
+		t.Errorf("got log %q", out)
+	}
+}
+
+func TestRecoverWithConfig(t *testing.T) {
+	var reported *PanicError
+	e := New()
+	e.Use(RecoverWithConfig(RecoverConfig{
+		StackSize: 64,
+		Reporter: func(c *Context, pe *PanicError) {
+			reported = pe
+		},
+	}))
+	e.GET("/", func(c *Context) error {
+		panic("oops")
+	})
+
+	if w := performRequest(e, http.MethodGet, "/"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d", w.Code)
+	}
+	if reported == nil || reported.Value != "oops" || len(reported.Stack) == 0 || len(reported.Stack) > 64 {
+		t.Fatalf("got report %+v", reported)
+	}
+}
+
+func TestRecoverRepanicsErrAbortHandler(t *testing.T) {
+	reported := false
+	e := New()
+	e.Use(RecoverWithConfig(RecoverConfig{
+		Reporter: func(c *Context, pe *PanicError) {
+			reported = true
+		},
+	}))
+	e.GET("/", func(c *Context) error {
+		panic(http.ErrAbortHandler)
+	})
+
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("got panic %v, want %v", r, http.ErrAbortHandler)
+		}
+		if reported {
+			t.Error("http.ErrAbortHandler was reported")
+		}
+	}()
+	performRequest(e, http.MethodGet, "/")
+}
@@ -0,0 +1,96 @@
+package pisces
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/xdatk/pisces/internal/constant"
+)
+
+const (
+	maxRequestIDLength = 128
+)
+
+// RequestIDConfig defines the config for RequestID middleware.
+type RequestIDConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+
+	// Header is the request and response header carrying the ID, default X-Request-ID.
+	Header string
+
+	// Generator generates a new ID when the request has no valid one, default 16 random bytes in hex.
+	Generator func() string
+
+	// Validator reports whether an incoming ID can be reused, the default accepts up to 128
+	// printable ASCII characters without spaces.
+	Validator func(string) bool
+}
+
+// DefaultRequestIDConfig is the default RequestID middleware config.
+var DefaultRequestIDConfig = RequestIDConfig{
+	Skipper:   DefaultSkipper,
+	Header:    constant.HeaderXRequestID,
+	Generator: generateRequestID,
+	Validator: validRequestID,
+}
+
+// RequestID returns a middleware which reuses the incoming request ID or generates one,
+// stores it on the Context, see Context.RequestID, and echoes it on the response.
+func RequestID() MiddlewareFunc {
+	return RequestIDWithConfig(DefaultRequestIDConfig)
+}
+
+// RequestIDWithConfig returns a RequestID middleware with config, see RequestID.
+func RequestIDWithConfig(config RequestIDConfig) MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultRequestIDConfig.Skipper
+	}
+	if config.Header == "" {
+		config.Header = DefaultRequestIDConfig.Header
+	}
+	if config.Generator == nil {
+		config.Generator = DefaultRequestIDConfig.Generator
+	}
+	if config.Validator == nil {
+		config.Validator = DefaultRequestIDConfig.Validator
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			id := c.Header(config.Header)
+			if !config.Validator(id) {
+				id = config.Generator()
+			}
+
+			c.requestID = id
+			c.SetHeader(config.Header, id)
+
+			return next(c)
+		}
+	}
+}
+
+func generateRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
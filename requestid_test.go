@@ -0,0 +1,105 @@
+package pisces
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	var got string
+	e := New()
+	e.Use(RequestID())
+	e.GET("/", func(c *Context) error {
+		got = c.RequestID()
+		return nil
+	})
+
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+	tests := []struct {
+		name     string
+		incoming string
+		reused   bool
+	}{
+		{"generated", "", false},
+		{"passed through", "abc-123_XYZ:1", true},
+		{"longest", strings.Repeat("a", 128), true},
+		{"too long", strings.Repeat("a", 129), false},
+		{"space", "abc 123", false},
+		{"control character", "abc\x01", false},
+		{"non ASCII", "abcé", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				r.Header.Set("X-Request-ID", tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+
+			if tt.reused && got != tt.incoming {
+				t.Errorf("got ID %q, want %q", got, tt.incoming)
+			}
+			if !tt.reused && !generated.MatchString(got) {
+				t.Errorf("got ID %q, want a generated one", got)
+			}
+			if h := w.Header().Get("X-Request-ID"); h != got {
+				t.Errorf("got header %q, want %q", h, got)
+			}
+		})
+	}
+}
+
+func TestRequestIDWithConfig(t *testing.T) {
+	e := New()
+	e.Use(RequestIDWithConfig(RequestIDConfig{
+		Header:    "X-Trace-ID",
+		Generator: func() string { return "generated" },
+		Validator: func(id string) bool { return strings.HasPrefix(id, "t-") },
+	}))
+	e.GET("/", func(c *Context) error {
+		return c.Text(http.StatusOK, c.RequestID())
+	})
+
+	for incoming, want := range map[string]string{"t-1": "t-1", "x-1": "generated"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Trace-ID", incoming)
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		if w.Body.String() != want || w.Header().Get("X-Trace-ID") != want {
+			t.Errorf("incoming %q: got %q and header %q, want %q", incoming, w.Body.String(), w.Header().Get("X-Trace-ID"), want)
+		}
+	}
+}
+
+func TestErrorResponsesCarryRequestID(t *testing.T) {
+	e := New()
+	e.Use(RequestID())
+
+	tests := []struct {
+		name        string
+		contentType string
+		want        string
+	}{
+		{"JSON", "application/json", `{"message":"Not Found","request_id":"req-1"}`},
+		{"text", "", "Not Found (request_id=req-1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/missing", nil)
+			r.Header.Set("X-Request-ID", "req-1")
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+
+			if w.Code != http.StatusNotFound || strings.TrimSpace(w.Body.String()) != tt.want {
+				t.Errorf("got %d %q, want %q", w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+}
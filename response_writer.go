@@ -2,6 +2,8 @@ package pisces
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"log"
 	"net"
 	"net/http"
@@ -70,9 +72,166 @@ func (r *responseWriter) Pusher() http.Pusher {
 	return nil
 }
 
+func (r *responseWriter) committed() bool {
+	return r.Committed
+}
+
 func (r *responseWriter) reset(w http.ResponseWriter) {
 	r.ResponseWriter = w
 	r.Size = 0
 	r.Status = http.StatusOK
 	r.Committed = false
 }
+
+// committer is implemented by the ResponseWriters of this package, which report whether
+// the response was written through them, see Context.IsCommitted.
+type committer interface {
+	committed() bool
+}
+
+// isCommitted reports whether the response was written through w,
+// false if w does not tell.
+func isCommitted(w http.ResponseWriter) bool {
+	c, ok := w.(committer)
+	return ok && c.committed()
+}
+
+// bodyAllowedForStatus reports whether a given response status code permits a body.
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent:
+		return false
+	case status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// statusRecorder is a pass-through ResponseWriter which records the status and the size of
+// the response written by the next handlers, whichever writers they wrap it in.
+type statusRecorder struct {
+	ResponseWriter
+	status      int
+	size        int
+	wroteHeader bool
+}
+
+func newStatusRecorder(w ResponseWriter) *statusRecorder {
+	return &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	if !r.wroteHeader {
+		r.status = statusCode
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *statusRecorder) Write(bytes []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	n, err := r.ResponseWriter.Write(bytes)
+	r.size += n
+	return n, err
+}
+
+func (r *statusRecorder) committed() bool {
+	return isCommitted(r.ResponseWriter)
+}
+
+// result returns the status and the size of the response, the status is the one
+// DefaultErrorHandler sends for err when nothing was written.
+func (r *statusRecorder) result(err error) (int, int) {
+	if err != nil && !r.wroteHeader {
+		return toHTTPError(err).Code, r.size
+	}
+	return r.status, r.size
+}
+
+var errHijackBuffered = errors.New("pisces: a buffered response can not be hijacked")
+
+// bufferWriter is a ResponseWriter which keeps the status, header and body in memory,
+// see writeTo to send them.
+type bufferWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+// newBufferWriter returns a bufferWriter whose header starts as a copy of header.
+func newBufferWriter(header http.Header) *bufferWriter {
+	b := &bufferWriter{
+		header: header.Clone(),
+		status: http.StatusOK,
+	}
+	if b.header == nil {
+		b.header = make(http.Header)
+	}
+	return b
+}
+
+func (b *bufferWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferWriter) Write(bytes []byte) (int, error) {
+	if !b.wroteHeader {
+		b.WriteHeader(http.StatusOK)
+	}
+	return b.body.Write(bytes)
+}
+
+func (b *bufferWriter) WriteHeader(statusCode int) {
+	if b.wroteHeader {
+		return
+	}
+	b.status = statusCode
+	b.wroteHeader = true
+}
+
+func (b *bufferWriter) committed() bool {
+	return b.wroteHeader
+}
+
+// Hijack always fails, the connection can not be taken over while the response is buffered.
+func (b *bufferWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errHijackBuffered
+}
+
+// Flush does nothing, the response is sent by writeTo.
+func (b *bufferWriter) Flush() {}
+
+// Pusher returns nil, the server push is not supported while the response is buffered.
+func (b *bufferWriter) Pusher() http.Pusher {
+	return nil
+}
+
+// writeTo replaces the header of w with the buffered one, then writes the status and the body.
+func (b *bufferWriter) writeTo(w http.ResponseWriter) error {
+	b.copyHeaderTo(w.Header())
+
+	w.WriteHeader(b.status)
+	if b.body.Len() == 0 {
+		return nil
+	}
+	_, err := w.Write(b.body.Bytes())
+	return err
+}
+
+// copyHeaderTo replaces header with the buffered one, for the responses written by the
+// error handler when nothing was buffered.
+func (b *bufferWriter) copyHeaderTo(header http.Header) {
+	for k := range header {
+		if _, ok := b.header[k]; !ok {
+			delete(header, k)
+		}
+	}
+	for k, v := range b.header {
+		header[k] = v
+	}
+}
@@ -101,21 +101,50 @@ type RouterGroup struct {
 	root        bool
 	basePath    string
 	middlewares []MiddlewareFunc
+	parent      *RouterGroup
 	engine      *Engine
+	// routed reports whether a route has been registered through the group or one of its children,
+	// the middleware chains of those routes are already compiled.
+	routed bool
 }
 
+// Use adds middleware to the group, it panics if a route has already been registered
+// through the group or one of its children.
 func (group *RouterGroup) Use(middlewares ...MiddlewareFunc) {
+	if group.routed {
+		panic("middleware must be registered before routes in group '" + group.basePath + "'")
+	}
+
 	group.middlewares = append(group.middlewares, middlewares...)
+
+	if group.root {
+		group.engine.compileFallbackHandlers()
+	}
 }
 
-func (group *RouterGroup) addHandler(method, path string, handler HandlerFunc, middlewares ...MiddlewareFunc) Routes {
-	m := make([]MiddlewareFunc, 0, len(group.middlewares)+len(middlewares))
-	m = append(m, group.middlewares...)
-	m = append(m, middlewares...)
-	group.engine.addRouter(method, path, handler, m...)
+func (group *RouterGroup) addHandler(method, relativePath string, handler HandlerFunc, middlewares ...MiddlewareFunc) Routes {
+	absolutePath := util.JoinPaths(group.basePath, relativePath)
+	group.engine.addRouter(method, absolutePath, handler, group.combineMiddlewares(middlewares)...)
+
+	for g := group; g != nil; g = g.parent {
+		g.routed = true
+	}
+
 	return group.returnRoutes()
 }
 
+// combineMiddlewares returns the middlewares of the group's ancestors, followed by the
+// middlewares of the group itself, followed by the given ones.
+func (group *RouterGroup) combineMiddlewares(middlewares []MiddlewareFunc) []MiddlewareFunc {
+	var combined []MiddlewareFunc
+	if group.parent != nil {
+		combined = group.parent.combineMiddlewares(group.middlewares)
+	} else {
+		combined = append(combined, group.middlewares...)
+	}
+	return append(combined, middlewares...)
+}
+
 func (group *RouterGroup) GET(path string, handler HandlerFunc, middlewares ...MiddlewareFunc) Routes {
 	return group.addHandler(http.MethodGet, path, handler, middlewares...)
 }
@@ -223,10 +252,13 @@ func (group *RouterGroup) StaticFS(relativePath string, filesystem http.FileSyst
 	return group.returnRoutes()
 }
 
+// Group creates a new router group. The middlewares of the group are applied after
+// the middlewares of its parent, including the ones added to the parent later on.
 func (group *RouterGroup) Group(path string, middlewares ...MiddlewareFunc) *RouterGroup {
 	return &RouterGroup{
 		basePath:    util.JoinPaths(group.basePath, path),
-		middlewares: append(group.middlewares, middlewares...),
+		middlewares: append([]MiddlewareFunc(nil), middlewares...),
+		parent:      group,
 		engine:      group.engine,
 	}
 }
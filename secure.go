@@ -0,0 +1,193 @@
+package pisces
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xdatk/pisces/internal/constant"
+)
+
+const (
+	// CSPNoncePlaceholder is replaced by the nonce of the request in CSPPolicy.Policy,
+	// for example "script-src 'self' {nonce}".
+	CSPNoncePlaceholder = "{nonce}"
+
+	cspNonceLength = 16
+)
+
+// HSTSPolicy is the Strict-Transport-Security policy, it is only sent on HTTPS responses.
+type HSTSPolicy struct {
+	// MaxAge is how long browsers must only use HTTPS, zero omits the header.
+	MaxAge time.Duration
+	// IncludeSubDomains applies the policy to the subdomains.
+	IncludeSubDomains bool
+	// Preload allows the domain to be included in the browsers preload lists.
+	Preload bool
+}
+
+// CSPPolicy is the Content-Security-Policy policy.
+type CSPPolicy struct {
+	// Policy is the list of directives, an empty policy omits the header.
+	// CSPNoncePlaceholder is replaced by a source expression allowing the nonce of the
+	// request, see Context.CSPNonce.
+	Policy string
+	// ReportOnly sends the policy in the Content-Security-Policy-Report-Only header,
+	// which reports violations without enforcing it.
+	ReportOnly bool
+}
+
+// SecureConfig defines the config for Secure middleware.
+// Empty values omit their header.
+type SecureConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+
+	// HSTS is the Strict-Transport-Security policy.
+	HSTS HSTSPolicy
+
+	// CSP is the Content-Security-Policy policy.
+	CSP CSPPolicy
+
+	// ContentTypeOptions is the X-Content-Type-Options header, "nosniff".
+	ContentTypeOptions string
+
+	// FrameOptions is the X-Frame-Options header, "DENY" or "SAMEORIGIN".
+	FrameOptions string
+
+	// ReferrerPolicy is the Referrer-Policy header, for example "strict-origin-when-cross-origin".
+	ReferrerPolicy string
+
+	// XSSProtection is the X-XSS-Protection header. Browser XSS auditors are removed or can be
+	// abused, "0" disables them.
+	XSSProtection string
+
+	// HTTPSRedirect redirects the requests whose scheme is not https, see Context.Scheme.
+	// It requires HTTPSHost or HTTPSAllowedHosts, the Host header is chosen by the client.
+	HTTPSRedirect bool
+
+	// HTTPSRedirectCode is the status code of the HTTPS redirect, default 308.
+	HTTPSRedirectCode int
+
+	// HTTPSHost is the host of the HTTPS redirect.
+	HTTPSHost string
+
+	// HTTPSAllowedHosts are the hosts requests are redirected to when HTTPSHost is empty.
+	// The request is redirected to its own host, without the port, if it is one of them,
+	// requests for other hosts fail with ErrBadRequest.
+	HTTPSAllowedHosts []string
+}
+
+// DefaultSecureConfig is the default Secure middleware config.
+var DefaultSecureConfig = SecureConfig{
+	Skipper:            DefaultSkipper,
+	HSTS:               HSTSPolicy{MaxAge: 365 * 24 * time.Hour, IncludeSubDomains: true},
+	ContentTypeOptions: "nosniff",
+	FrameOptions:       "SAMEORIGIN",
+	ReferrerPolicy:     "strict-origin-when-cross-origin",
+	XSSProtection:      "0",
+	HTTPSRedirectCode:  http.StatusPermanentRedirect,
+}
+
+// Secure returns a middleware which sets security response headers: HSTS on HTTPS,
+// X-Content-Type-Options, X-Frame-Options, Referrer-Policy and X-XSS-Protection.
+func Secure() MiddlewareFunc {
+	return SecureWithConfig(DefaultSecureConfig)
+}
+
+// SecureWithConfig returns a Secure middleware with config, see Secure.
+func SecureWithConfig(config SecureConfig) MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultSecureConfig.Skipper
+	}
+	if config.HTTPSRedirectCode == 0 {
+		config.HTTPSRedirectCode = DefaultSecureConfig.HTTPSRedirectCode
+	}
+	if config.HTTPSRedirect && config.HTTPSHost == "" && len(config.HTTPSAllowedHosts) == 0 {
+		panic("https redirect requires a host or allowed hosts")
+	}
+
+	allowedHosts := make(map[string]struct{}, len(config.HTTPSAllowedHosts))
+	for _, host := range config.HTTPSAllowedHosts {
+		allowedHosts[strings.ToLower(host)] = struct{}{}
+	}
+
+	hsts := ""
+	if config.HSTS.MaxAge > 0 {
+		hsts = "max-age=" + strconv.FormatInt(int64(config.HSTS.MaxAge/time.Second), 10)
+		if config.HSTS.IncludeSubDomains {
+			hsts += "; includeSubDomains"
+		}
+		if config.HSTS.Preload {
+			hsts += "; preload"
+		}
+	}
+
+	cspHeader := constant.HeaderContentSecurityPolicy
+	if config.CSP.ReportOnly {
+		cspHeader = constant.HeaderContentSecurityPolicyReportOnly
+	}
+	cspNonce := strings.Contains(config.CSP.Policy, CSPNoncePlaceholder)
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			https := c.Scheme() == "https"
+			if config.HTTPSRedirect && !https {
+				host := config.HTTPSHost
+				if host == "" {
+					host = strings.ToLower(c.Request.Host)
+					if h, _, err := net.SplitHostPort(host); err == nil {
+						host = h
+					}
+					if _, ok := allowedHosts[host]; !ok {
+						return ErrBadRequest
+					}
+				}
+				return c.Redirect(config.HTTPSRedirectCode, "https://"+host+c.Request.URL.RequestURI())
+			}
+
+			header := c.Writer.Header()
+			if hsts != "" && https {
+				header.Set(constant.HeaderStrictTransportSecurity, hsts)
+			}
+			if config.ContentTypeOptions != "" {
+				header.Set(constant.HeaderXContentTypeOptions, config.ContentTypeOptions)
+			}
+			if config.FrameOptions != "" {
+				header.Set(constant.HeaderXFrameOptions, config.FrameOptions)
+			}
+			if config.ReferrerPolicy != "" {
+				header.Set(constant.HeaderReferrerPolicy, config.ReferrerPolicy)
+			}
+			if config.XSSProtection != "" {
+				header.Set(constant.HeaderXXSSProtection, config.XSSProtection)
+			}
+			if config.CSP.Policy != "" {
+				policy := config.CSP.Policy
+				if cspNonce {
+					c.cspNonce = generateCSPNonce()
+					policy = strings.ReplaceAll(policy, CSPNoncePlaceholder, "'nonce-"+c.cspNonce+"'")
+				}
+				header.Set(cspHeader, policy)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+func generateCSPNonce() string {
+	b := make([]byte, cspNonceLength)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
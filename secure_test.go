@@ -0,0 +1,155 @@
+package pisces
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestSecureHeaders(t *testing.T) {
+	var nonce string
+	e := New()
+	e.Use(SecureWithConfig(SecureConfig{
+		HSTS:               HSTSPolicy{MaxAge: time.Hour, IncludeSubDomains: true, Preload: true},
+		CSP:                CSPPolicy{Policy: "script-src 'self' {nonce}"},
+		ContentTypeOptions: "nosniff",
+		FrameOptions:       "DENY",
+		ReferrerPolicy:     "no-referrer",
+		XSSProtection:      "0",
+	}))
+	e.GET("/", func(c *Context) error {
+		nonce = c.CSPNonce()
+		return c.NoContent(http.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		target string
+		hsts   string
+	}{
+		{"http", "http://example.com/", ""},
+		{"https", "https://example.com/", "max-age=3600; includeSubDomains; preload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			want := map[string]string{
+				"Strict-Transport-Security": tt.hsts,
+				"Content-Security-Policy":   "script-src 'self' 'nonce-" + nonce + "'",
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "DENY",
+				"Referrer-Policy":           "no-referrer",
+				"X-Xss-Protection":          "0",
+			}
+			for k, v := range want {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("got %s %q, want %q", k, got, v)
+				}
+			}
+			if !regexp.MustCompile(`^[A-Za-z0-9+/]{22}==$`).MatchString(nonce) {
+				t.Errorf("got nonce %q", nonce)
+			}
+		})
+	}
+}
+
+func TestSecureDefaultsAndReportOnly(t *testing.T) {
+	e := New()
+	config := DefaultSecureConfig
+	config.CSP = CSPPolicy{Policy: "default-src 'self'", ReportOnly: true}
+	e.Use(SecureWithConfig(config))
+	e.GET("/", func(c *Context) error {
+		if c.CSPNonce() != "" {
+			t.Errorf("got nonce %q without a placeholder", c.CSPNonce())
+		}
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+
+	want := map[string]string{
+		"Strict-Transport-Security":           "max-age=31536000; includeSubDomains",
+		"Content-Security-Policy":             "",
+		"Content-Security-Policy-Report-Only": "default-src 'self'",
+		"X-Frame-Options":                     "SAMEORIGIN",
+		"Referrer-Policy":                     "strict-origin-when-cross-origin",
+	}
+	for k, v := range want {
+		if got := w.Header().Get(k); got != v {
+			t.Errorf("got %s %q, want %q", k, got, v)
+		}
+	}
+}
+
+func TestSecureHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   SecureConfig
+		target   string
+		host     string
+		code     int
+		location string
+	}{
+		{
+			name:     "fixed host",
+			config:   SecureConfig{HTTPSRedirect: true, HTTPSHost: "example.com"},
+			target:   "http://evil.example/a?b=1",
+			code:     http.StatusPermanentRedirect,
+			location: "https://example.com/a?b=1",
+		},
+		{
+			name:     "allowed host",
+			config:   SecureConfig{HTTPSRedirect: true, HTTPSAllowedHosts: []string{"example.com", "www.example.com"}, HTTPSRedirectCode: http.StatusMovedPermanently},
+			target:   "http://www.example.com/a",
+			host:     "WWW.example.com:80",
+			code:     http.StatusMovedPermanently,
+			location: "https://www.example.com/a",
+		},
+		{
+			name:   "host not allowed",
+			config: SecureConfig{HTTPSRedirect: true, HTTPSAllowedHosts: []string{"example.com"}},
+			target: "http://evil.example/a",
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:   "already https",
+			config: SecureConfig{HTTPSRedirect: true, HTTPSHost: "example.com"},
+			target: "https://example.com/a",
+			code:   http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := New()
+			e.Use(SecureWithConfig(tt.config))
+			e.GET("/a", func(c *Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.host != "" {
+				r.Host = tt.host
+			}
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+
+			if w.Code != tt.code || w.Header().Get("Location") != tt.location {
+				t.Errorf("got %d to %q, want %d to %q", w.Code, w.Header().Get("Location"), tt.code, tt.location)
+			}
+		})
+	}
+}
+
+func TestSecureHTTPSRedirectRequiresHost(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+	SecureWithConfig(SecureConfig{HTTPSRedirect: true})
+}
@@ -0,0 +1,180 @@
+package pisces
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultShutdownTimeout   = 10 * time.Second
+	defaultReadHeaderTimeout = 10 * time.Second
+)
+
+var (
+	ErrServerRunning    = errors.New("pisces: server is already running")
+	ErrServerNotRunning = errors.New("pisces: server is not running")
+)
+
+// StartHook is called before the server starts accepting connections.
+type StartHook func() error
+
+// ShutdownHook is called after the server has drained its in-flight requests.
+type ShutdownHook func(context.Context) error
+
+// OnStart registers hooks run in order before the server starts accepting connections,
+// the first error aborts the start.
+func (e *Engine) OnStart(hooks ...StartHook) {
+	e.onStart = append(e.onStart, hooks...)
+}
+
+// OnShutdown registers hooks run in order once the server has been shut down.
+func (e *Engine) OnShutdown(hooks ...ShutdownHook) {
+	e.onShutdown = append(e.onShutdown, hooks...)
+}
+
+// Run attaches the engine to a http.Server and starts listening and serving HTTP requests.
+// It blocks until the server is shut down, either by Shutdown or by one of the shutdown signals.
+func (e *Engine) Run(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return e.RunListener(l)
+}
+
+// RunTLS works like Run but serves HTTPS requests with the given certificate and key files.
+func (e *Engine) RunTLS(addr, certFile, keyFile string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return e.serve(l, func(s *http.Server) error {
+		return s.ServeTLS(l, certFile, keyFile)
+	})
+}
+
+// RunUnix works like Run but listens on the given unix socket file,
+// an existing socket is removed first.
+func (e *Engine) RunUnix(file string) error {
+	if fi, err := os.Lstat(file); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+
+	l, err := net.Listen("unix", file)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file)
+
+	return e.RunListener(l)
+}
+
+// RunListener works like Run but serves HTTP requests on the given listener,
+// which is closed when it returns.
+func (e *Engine) RunListener(l net.Listener) error {
+	return e.serve(l, func(s *http.Server) error {
+		return s.Serve(l)
+	})
+}
+
+// Shutdown gracefully shuts down the running server, it stops accepting new connections,
+// waits for in-flight requests to finish or ctx to be done and runs the OnShutdown hooks,
+// once even if Shutdown is called concurrently.
+func (e *Engine) Shutdown(ctx context.Context) error {
+	e.serverMu.Lock()
+	srv, done, once := e.server, e.serverDone, e.serverShutdown
+	e.serverMu.Unlock()
+
+	if srv == nil {
+		return ErrServerNotRunning
+	}
+
+	err := srv.Shutdown(ctx)
+
+	once.Do(func() {
+		for _, hook := range e.onShutdown {
+			if herr := hook(ctx); herr != nil && err == nil {
+				err = herr
+			}
+		}
+	})
+
+	e.serverMu.Lock()
+	if e.server == srv {
+		e.server = nil
+		e.serverDone = nil
+		e.serverShutdown = nil
+		close(done)
+	}
+	e.serverMu.Unlock()
+
+	return err
+}
+
+// serve runs serve with a new http.Server, l is closed if the server does not start.
+func (e *Engine) serve(l net.Listener, serve func(*http.Server) error) error {
+	srv := &http.Server{Handler: e, ReadHeaderTimeout: e.readHeaderTimeout}
+	done := make(chan struct{})
+
+	e.serverMu.Lock()
+	if e.server != nil {
+		e.serverMu.Unlock()
+		_ = l.Close()
+		return ErrServerRunning
+	}
+	e.server = srv
+	e.serverDone = done
+	e.serverShutdown = new(sync.Once)
+	e.serverMu.Unlock()
+
+	for _, hook := range e.onStart {
+		if err := hook(); err != nil {
+			e.serverMu.Lock()
+			e.server = nil
+			e.serverDone = nil
+			e.serverShutdown = nil
+			e.serverMu.Unlock()
+			_ = l.Close()
+			return err
+		}
+	}
+
+	var sig chan os.Signal
+	if len(e.shutdownSignals) > 0 {
+		sig = make(chan os.Signal, 1)
+		signal.Notify(sig, e.shutdownSignals...)
+		defer signal.Stop(sig)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- serve(srv)
+	}()
+
+	select {
+	case err := <-errs:
+		if err != http.ErrServerClosed {
+			_ = e.Shutdown(context.Background())
+			return err
+		}
+		<-done
+		return nil
+	case <-sig:
+		ctx, cancel := context.WithTimeout(context.Background(), e.shutdownTimeout)
+		defer cancel()
+		return e.Shutdown(ctx)
+	}
+}
+
+func defaultShutdownSignals() []os.Signal {
+	return []os.Signal{os.Interrupt, syscall.SIGTERM}
+}
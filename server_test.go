@@ -0,0 +1,266 @@
+package pisces
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startEngine(t *testing.T, e *Engine) (string, chan error) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- e.RunListener(l)
+	}()
+
+	return "http://" + l.Addr().String(), errs
+}
+
+func TestEngineShutdownDrainsInFlightRequests(t *testing.T) {
+	var order []string
+	var mu sync.Mutex
+	record := func(s string) {
+		mu.Lock()
+		order = append(order, s)
+		mu.Unlock()
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	e := New(WithShutdownSignals())
+	e.OnStart(func() error {
+		record("start-1")
+		return nil
+	}, func() error {
+		record("start-2")
+		return nil
+	})
+	e.OnShutdown(func(ctx context.Context) error {
+		record("shutdown-1")
+		return nil
+	}, func(ctx context.Context) error {
+		record("shutdown-2")
+		return nil
+	})
+	e.GET("/slow", func(c *Context) error {
+		close(started)
+		<-release
+		record("handler")
+		return c.Text(http.StatusOK, "done")
+	})
+
+	addr, errs := startEngine(t, e)
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(addr + "/slow")
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		results <- result{body: string(b), err: err}
+	}()
+
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- e.Shutdown(context.Background())
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if _, err := http.Get(addr + "/slow"); err == nil {
+		t.Fatal("expected new connections to be refused during shutdown")
+	}
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown returned before in-flight request finished: %v", err)
+	default:
+	}
+
+	close(release)
+
+	r := <-results
+	if r.err != nil || r.body != "done" {
+		t.Fatalf("in-flight request got body %q, err %v", r.body, r.err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	expected := []string{"start-1", "start-2", "handler", "shutdown-1", "shutdown-2"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("hooks ran in order %v, expected %v", order, expected)
+	}
+}
+
+func TestEngineShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	e := New(WithShutdownSignals())
+	e.GET("/slow", func(c *Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+
+	addr, errs := startEngine(t, e)
+	go http.Get(addr + "/slow")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := e.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+}
+
+func TestEngineRunTwice(t *testing.T) {
+	e := New(WithShutdownSignals())
+	_, errs := startEngine(t, e)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		e.serverMu.Lock()
+		running := e.server != nil
+		e.serverMu.Unlock()
+		if running || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.RunListener(l); err != ErrServerRunning {
+		t.Fatalf("expected %v, got %v", ErrServerRunning, err)
+	}
+	if conn, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		conn.Close()
+		t.Fatal("expected the listener to be closed")
+	}
+
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-errs
+
+	if err := e.Shutdown(context.Background()); err != ErrServerNotRunning {
+		t.Fatalf("expected %v, got %v", ErrServerNotRunning, err)
+	}
+}
+
+func TestEngineStartHookErrorClosesListener(t *testing.T) {
+	errBoom := errors.New("boom")
+	e := New(WithShutdownSignals())
+	e.OnStart(func() error {
+		return errBoom
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.RunListener(l); err != errBoom {
+		t.Fatalf("expected %v, got %v", errBoom, err)
+	}
+	if conn, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		conn.Close()
+		t.Fatal("expected the listener to be closed")
+	}
+	if err := e.Shutdown(context.Background()); err != ErrServerNotRunning {
+		t.Fatalf("expected %v, got %v", ErrServerNotRunning, err)
+	}
+}
+
+func TestEngineConcurrentShutdownRunsHooksOnce(t *testing.T) {
+	var calls int32
+	e := New(WithShutdownSignals())
+	e.OnShutdown(func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	addr, errs := startEngine(t, e)
+	waitRunning(t, addr)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = e.Shutdown(context.Background())
+		}()
+	}
+	wg.Wait()
+	<-errs
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("shutdown hooks ran %d times", n)
+	}
+}
+
+func TestEngineRunUnixKeepsRegularFiles(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pisces.sock")
+	if err := ioutil.WriteFile(file, []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	e := New(WithShutdownSignals())
+	if err := e.RunUnix(file); err == nil {
+		t.Fatal("expected RunUnix to fail on a regular file")
+	}
+	if b, err := ioutil.ReadFile(file); err != nil || string(b) != "data" {
+		t.Fatalf("regular file was removed: %v", err)
+	}
+}
+
+func waitRunning(t *testing.T, addr string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		resp, err := http.Get(addr)
+		if err == nil {
+			resp.Body.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,111 @@
+package pisces
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// TimeoutConfig defines the config for Timeout middleware.
+type TimeoutConfig struct {
+	// Skipper defines a function to skip middleware.
+	Skipper Skipper
+
+	// Timeout is the duration the next handlers have to produce a response.
+	Timeout time.Duration
+
+	// StatusCode is the status of the HTTPError returned on timeout,
+	// http.StatusServiceUnavailable by default or http.StatusGatewayTimeout.
+	StatusCode int
+
+	// Message is the message of the HTTPError returned on timeout, default the status text.
+	Message interface{}
+
+	// RouteTimeouts overrides Timeout for the given route templates, see Context.FullPath.
+	// A non-positive duration disables the timeout of the route.
+	RouteTimeouts map[string]time.Duration
+}
+
+// DefaultTimeoutConfig is the default Timeout middleware config.
+var DefaultTimeoutConfig = TimeoutConfig{
+	Skipper:    DefaultSkipper,
+	StatusCode: http.StatusServiceUnavailable,
+}
+
+// Timeout returns a middleware which adds a deadline to the request's context and returns
+// a 503 HTTPError if the next handlers have not returned when it passes.
+func Timeout(timeout time.Duration) MiddlewareFunc {
+	config := DefaultTimeoutConfig
+	config.Timeout = timeout
+	return TimeoutWithConfig(config)
+}
+
+// TimeoutWithConfig returns a Timeout middleware with config, see Timeout.
+//
+// The next handlers run on another goroutine with a copy of the Context whose response is
+// buffered, it is sent once they return in time and discarded otherwise, so late writes never
+// race with the timeout response. Values set on the copy are not visible to the previous
+// middlewares, and the response can neither be flushed nor hijacked.
+// Handlers should stop their work when the Context is done.
+func TimeoutWithConfig(config TimeoutConfig) MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultTimeoutConfig.Skipper
+	}
+	if config.StatusCode == 0 {
+		config.StatusCode = DefaultTimeoutConfig.StatusCode
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c *Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			timeout := config.Timeout
+			if d, ok := config.RouteTimeouts[c.fullPath]; ok {
+				timeout = d
+			}
+			if timeout <= 0 {
+				return next(c)
+			}
+
+			ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+			defer cancel()
+
+			bw := newBufferWriter(c.Writer.Header())
+			cc := c.clone(bw)
+			cc.Request = c.Request.WithContext(ctx)
+
+			done := make(chan error, 1)
+			panics := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panics <- p
+					}
+				}()
+				done <- next(cc)
+			}()
+
+			select {
+			case p := <-panics:
+				panic(p)
+			case err := <-done:
+				if !bw.wroteHeader {
+					bw.copyHeaderTo(c.Writer.Header())
+					return err
+				}
+				if werr := bw.writeTo(c.Writer); err == nil {
+					err = werr
+				}
+				return err
+			case <-ctx.Done():
+				he := NewHTTPError(config.StatusCode)
+				if config.Message != nil {
+					he.Message = config.Message
+				}
+				return he.SetInternal(ctx.Err())
+			}
+		}
+	}
+}
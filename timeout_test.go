@@ -0,0 +1,82 @@
+package pisces
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	e := New()
+	e.Use(TimeoutWithConfig(TimeoutConfig{
+		Timeout:       20 * time.Millisecond,
+		RouteTimeouts: map[string]time.Duration{"/slow/unlimited": 0},
+	}))
+	e.GET("/fast", func(c *Context) error {
+		c.SetHeader("X-Handler", "fast")
+		return c.Text(http.StatusCreated, "done")
+	})
+	e.GET("/slow", func(c *Context) error {
+		<-c.Done()
+		return c.Err()
+	})
+	e.GET("/slow/unlimited", func(c *Context) error {
+		time.Sleep(40 * time.Millisecond)
+		return c.NoContent(http.StatusOK)
+	})
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/fast", http.StatusCreated},
+		{"/slow", http.StatusServiceUnavailable},
+		{"/slow/unlimited", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := performRequest(e, http.MethodGet, tt.path); w.Code != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.path, w.Code, tt.code)
+		}
+	}
+
+	w := performRequest(e, http.MethodGet, "/fast")
+	if w.Header().Get("X-Handler") != "fast" || w.Body.String() != "done" {
+		t.Errorf("got header %q and body %q", w.Header().Get("X-Handler"), w.Body.String())
+	}
+}
+
+func TestTimeoutKeepsHeadersOfErrors(t *testing.T) {
+	e := New()
+	e.Use(Timeout(time.Second))
+	e.GET("/", func(c *Context) error {
+		c.SetHeader("WWW-Authenticate", "Bearer")
+		return ErrUnauthorized
+	})
+
+	w := performRequest(e, http.MethodGet, "/")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("got %d with WWW-Authenticate %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+// TestTimeoutLateWrites is meant to run with -race: the handler keeps writing to its
+// Context after the timeout response is sent.
+func TestTimeoutLateWrites(t *testing.T) {
+	wrote := make(chan struct{})
+	e := New()
+	e.Use(TimeoutWithConfig(TimeoutConfig{Timeout: 10 * time.Millisecond, StatusCode: http.StatusGatewayTimeout}))
+	e.GET("/", func(c *Context) error {
+		defer close(wrote)
+		<-c.Done()
+		c.Set("late", true)
+		c.SetHeader("X-Late", "1")
+		return c.Text(http.StatusOK, "late")
+	})
+
+	w := performRequest(e, http.MethodGet, "/")
+	<-wrote
+
+	if w.Code != http.StatusGatewayTimeout || w.Header().Get("X-Late") != "" {
+		t.Errorf("got %d %q with X-Late %q", w.Code, w.Body.String(), w.Header().Get("X-Late"))
+	}
+}
@@ -1,5 +1,11 @@
 package pisces
 
+import "errors"
+
+// ErrValidatorNotRegistered is returned by Context.Validate when the engine has no Validator,
+// see WithValidator.
+var ErrValidatorNotRegistered = errors.New("pisces: validator not registered")
+
 type Validator interface {
 	Validate(interface{}) error
 }